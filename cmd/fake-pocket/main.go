@@ -0,0 +1,33 @@
+//
+// main.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+// fake-pocket runs a local stand-in of Pocket API that records actions.
+// Point pocket.base_url to it and inspect received actions at /fake/actions.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"github.com/teerapap/feed-to-pocket/internal/fakepocket"
+	"github.com/teerapap/feed-to-pocket/internal/log"
+)
+
+func main() {
+	log.Initialize(os.Stdout)
+
+	var listenAddr string
+	flag.StringVar(&listenAddr, "listen", "127.0.0.1:8081", "Listen address")
+	flag.Parse()
+
+	log.Infof("Fake Pocket server is listening on %s", listenAddr)
+	if err := http.ListenAndServe(listenAddr, fakepocket.New()); err != nil {
+		log.Errorf("%s", err)
+		os.Exit(1)
+	}
+}
@@ -8,19 +8,29 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/teerapap/feed-to-pocket/internal/feed"
 	"github.com/teerapap/feed-to-pocket/internal/http_server"
 	"github.com/teerapap/feed-to-pocket/internal/log"
 	"github.com/teerapap/feed-to-pocket/internal/pocket"
+	"github.com/teerapap/feed-to-pocket/internal/sink"
 	"github.com/teerapap/feed-to-pocket/internal/util"
+	"github.com/teerapap/feed-to-pocket/internal/wallabag"
+	"github.com/teerapap/feed-to-pocket/internal/webhook"
 )
 
 // Command-line Parsing
@@ -28,6 +38,7 @@ var help bool
 var verbose bool
 var version bool
 var dryRun bool
+var daemon bool
 var configFile string
 
 func init() {
@@ -40,6 +51,7 @@ func init() {
 	flag.BoolVar(&version, "version", false, "Show version")
 	flag.BoolVar(&version, "v", false, "Show version")
 	flag.BoolVar(&dryRun, "dry-run", false, "Dry run mode")
+	flag.BoolVar(&daemon, "daemon", false, "Daemon mode. Poll feed sources at their intervals until interrupted")
 	flag.StringVar(&configFile, "config", "", "Config file")
 	flag.StringVar(&configFile, "c", "", "Config file")
 }
@@ -48,7 +60,12 @@ func helpUsage(msg string) {
 	if msg != "" {
 		log.Error(msg)
 	}
-	fmt.Fprintf(flag.CommandLine.Output(), "%s [options] <input_pdf_file>\n", os.Args[0])
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "%s [options] [command]\n\n", os.Args[0])
+	fmt.Fprintln(out, "Commands:")
+	fmt.Fprintln(out, "  auth\tAuthorize with Pocket and save the access token")
+	fmt.Fprintln(out, "  serve\tServe stored contents of previous runs until interrupted")
+	fmt.Fprintln(out, "\nOptions:")
 	flag.PrintDefaults()
 	if msg != "" {
 		os.Exit(1)
@@ -77,9 +94,16 @@ type MainConfig struct {
 }
 
 type Config struct {
-	Main   MainConfig    `toml:"main"`
-	Pocket pocket.Config `toml:"pocket"`
-	Rss    feed.Config   `toml:"rss,omitempty"`
+	Main     MainConfig      `toml:"main"`
+	Pocket   pocket.Config   `toml:"pocket"`
+	Wallabag wallabag.Config `toml:"wallabag,omitempty"`
+	Webhook  webhook.Config  `toml:"webhook,omitempty"`
+	Rss      feed.Config     `toml:"rss,omitempty"`
+}
+
+// Removed config keys and what to do instead
+var deprecatedKeys = map[string]string{
+	"main.http_server.random_url": "Content urls are always signed and unguessable. Remove it from the config file.",
 }
 
 func main() {
@@ -106,74 +130,236 @@ func main() {
 
 	// Read config file
 	var conf Config
-	_ = util.Must1(toml.DecodeFile(configFile, &conf))("parsing config file")
+	meta := util.Must1(toml.DecodeFile(configFile, &conf))("parsing config file")
+	for _, key := range meta.Undecoded() {
+		if reason, ok := deprecatedKeys[key.String()]; ok {
+			log.Warnf("%s is deprecated and ignored. %s", key, reason)
+		}
+	}
 	conf.Main.DataDir = util.Must1(filepath.Abs(conf.Main.DataDir))("checking data directory")
+	util.Must(conf.Rss.Validate())("validating rss config")
 
-	// Create Pocket client
-	pc := util.Must1(pocket.NewClient(conf.Pocket))("creating Pocket client")
+	switch command := flag.Arg(0); command {
+	case "":
+	case "auth":
+		authorize(conf)
+		return
+	case "serve":
+		serve(conf)
+		return
+	default:
+		helpUsage(fmt.Sprintf("unknown command: %s", command))
+	}
+
+	// Create sinks
+	pc := util.Must1(pocket.NewClient(conf.Pocket, conf.Main.DataDir))("creating Pocket client")
+	sinks := map[string]sink.Sink{
+		"pocket": pc,
+	}
+	if conf.Wallabag.Url != "" {
+		sinks["wallabag"] = util.Must1(wallabag.NewClient(conf.Wallabag))("creating Wallabag client")
+	}
+	if conf.Webhook.Url != "" {
+		sinks["webhook"] = util.Must1(webhook.NewClient(conf.Webhook))("creating webhook client")
+	}
+	util.Must(checkSinks(conf.Rss, sinks))("checking sinks of rss sources")
 
 	// Prepare http server
 	var hc *http_server.Server = nil
 	startServerOnce := sync.OnceValues(func() (*http_server.Server, error) {
-		return http_server.NewServer(conf.Main.HttpServer)
+		return http_server.NewServer(conf.Main.HttpServer, conf.Main.DataDir)
 	})
 
+	// Shut down gracefully on signals
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Infof("Received %s. Shutting down after in-flight deliveries finish", sig)
+		// Next signal terminates immediately
+		signal.Stop(sigs)
+		cancel()
+	}()
+
+	// Consumer is called concurrently in daemon mode
+	var statsMu sync.Mutex // guards totals and hc
 	totalItems := 0
 	totalItemErrors := 0
+	totalUnfetched := 0
+	addTotals := func(items, itemErrors, unfetched int) {
+		statsMu.Lock()
+		defer statsMu.Unlock()
+		totalItems = totalItems + items
+		totalItemErrors = totalItemErrors + itemErrors
+		totalUnfetched = totalUnfetched + unfetched
+	}
+	// Sinks are not safe for concurrent use
+	var sinkMu sync.Mutex
 
-	// Find new items from feed sources
-	feed.FindNewItems(conf.Rss, conf.Main.DataDir, func(items []feed.Item, src feed.Source) (bool, error) {
-		// Add to new items to Pocket
-		totalItems = totalItems + len(items)
+	consumer := func(items []feed.Item, src feed.Source, logs log.Logger) ([]error, error) {
+		// Add to new items to the sink
+		addTotals(len(items), 0, 0)
+		dest := sinks[cmp.Or(src.Sink, "pocket")]
 		if dryRun {
-			log.Info("Skip adding to pocket because of dry-run mode")
-			return false, nil
+			logs.Infof("Skip adding to %s because of dry-run mode", dest.Name())
+			return nil, nil
 		}
-		log.Indent()
-		defer log.Unindent()
+		logs.Indent()
+		defer logs.Unindent()
 
-		scList := make([]*http_server.Content, 0)
-		pItems := make([]pocket.NewItem, 0, len(items))
-		for _, item := range items {
-			finalUrl := item.Url
+		// Get and start http server if needed
+		var server *http_server.Server
+		startServer := func() error {
+			var err error
+			server, err = startServerOnce()
+			if err != nil {
+				return fmt.Errorf("starting content server: %w", err)
+			}
+			statsMu.Lock()
+			hc = server
+			statsMu.Unlock()
+			return nil
+		}
+
+		// Skip items already in Pocket list
+		itemErrs := make([]error, len(items))
+		existing := make([]bool, len(items))
+		if dest == pc && conf.Pocket.SkipExisting {
+			urls := make([]string, 0, 2*len(items))
+			for _, item := range items {
+				urls = append(urls, item.Url)
+			}
 			if src.ForceArticleView {
+				// Pocket saved the url of served content instead of the item url
+				if err := startServer(); err != nil {
+					return nil, err
+				}
+				for _, item := range items {
+					urls = append(urls, server.ContentUrl(item.Id))
+				}
+			}
+			sinkMu.Lock()
+			found, err := pc.Existing(ctx, urls)
+			sinkMu.Unlock()
+			if err != nil {
+				logs.Warnf("Cannot check existing items in Pocket: %s", err)
+			} else {
+				for i := range items {
+					existing[i] = found[i] || (src.ForceArticleView && found[len(items)+i])
+				}
+			}
+		}
 
-				// Get and start http server if needed
-				var err error
-				hc, err = startServerOnce()
+		// Build documents of items to add only. Full text articles of skipped items are not extracted.
+		if src.ForceArticleView {
+			toBuild := make([]*feed.Item, 0, len(items))
+			buildIndex := make([]int, 0, len(items)) // index in items of each item to build
+			for i := range items {
+				if !existing[i] {
+					toBuild = append(toBuild, &items[i])
+					buildIndex = append(buildIndex, i)
+				}
+			}
+			failed := 0
+			for k, err := range feed.BuildDocuments(ctx, logs, src, toBuild) {
 				if err != nil {
-					return false, fmt.Errorf("starting content server: %w", err)
+					itemErrs[buildIndex[k]] = fmt.Errorf("building document: %w", err)
+					failed++
 				}
+			}
+			addTotals(0, failed, 0)
+		}
 
-				sc := hc.ServeContent(item.Id, item.Document)
-				scList = append(scList, sc)
+		// served content of each item if any
+		scList := make([]*http_server.Content, len(items))
+		sItems := make([]sink.Item, 0, len(items))
+		sIndex := make([]int, 0, len(items)) // index in items of each sink item
+		for i, item := range items {
+			if existing[i] {
+				logs.Infof("Skip %s because it is already in Pocket", item.Url)
+				continue
+			}
+			if itemErrs[i] != nil {
+				continue
+			}
+			finalUrl := item.Url
+			if src.ForceArticleView {
+
+				if err := startServer(); err != nil {
+					return nil, err
+				}
+				sc := server.ServeContent(item.Id, item.Document)
+				scList[i] = sc
 				finalUrl = sc.FullUrl
 			}
-			pItems = append(pItems, pocket.NewItem{
+			sItems = append(sItems, sink.Item{
 				Url:   finalUrl,
 				Title: item.Title,
-				Time:  item.Time.Unix(),
+				Time:  item.Time,
 				Tags:  item.Tags,
 			})
+			sIndex = append(sIndex, i)
 		}
 
-		if err := pc.AddItems(pItems); err != nil {
-			totalItemErrors = totalItemErrors + len(items)
-			return false, fmt.Errorf("calling Pocket API to add new items: %w", err)
-		}
+		sinkMu.Lock()
+		results := dest.AddItems(ctx, sItems)
+		sinkMu.Unlock()
+		addTotals(0, sink.CountFailed(results), 0)
 
-		var syncAll sync.WaitGroup
-		for _, sc := range scList {
-			syncAll.Add(1)
-			go func() {
-				defer syncAll.Done()
-				<-sc.Done
-			}()
+		// sink items of which content is served
+		served := make([]int, 0)
+		for j, result := range results {
+			i := sIndex[j]
+			itemErrs[i] = result.Err
+			if scList[i] != nil && result.Ok() {
+				served = append(served, j)
+			}
+		}
+		if len(served) == 0 {
+			return itemErrs, nil
 		}
+
 		// wait for all servings content to be fetched once before continue
-		syncAll.Wait()
-		return true, nil
-	})
+		fetchTimeout := cmp.Or(conf.Main.HttpServer.FetchTimeout, http_server.DefaultFetchTimeout)
+		contentOf := func(j int) *http_server.Content { return scList[sIndex[j]] }
+		unfetched := waitFetched(ctx, served, contentOf, fetchTimeout)
+		if len(unfetched) > 0 && conf.Main.HttpServer.FetchRetry && ctx.Err() == nil {
+			logs.Warnf("%d contents are not fetched in %s. Adding them to %s again", len(unfetched), fetchTimeout, dest.Name())
+			retryItems := make([]sink.Item, 0, len(unfetched))
+			for _, j := range unfetched {
+				retryItems = append(retryItems, sItems[j])
+			}
+			sinkMu.Lock()
+			retryResults := dest.AddItems(ctx, retryItems)
+			sinkMu.Unlock()
+			retried := make([]int, 0, len(unfetched))
+			for k, result := range retryResults {
+				if result.Ok() {
+					retried = append(retried, unfetched[k])
+				}
+			}
+			unfetched = waitFetched(ctx, retried, contentOf, fetchTimeout)
+		}
+		for _, j := range unfetched {
+			logs.Warnf("Content of %s is not fetched by %s in %s", items[sIndex[j]].Url, dest.Name(), fetchTimeout)
+		}
+		addTotals(0, 0, len(unfetched))
+
+		// Later fetches are served from the store
+		for _, j := range served {
+			server.Release(contentOf(j))
+		}
+		return itemErrs, nil
+	}
+
+	// Find new items from feed sources
+	if daemon {
+		feed.Poll(ctx, conf.Rss, conf.Main.DataDir, consumer)
+	} else {
+		feed.FindNewItems(ctx, conf.Rss, conf.Main.DataDir, consumer)
+	}
 
 	if hc != nil {
 		if err := hc.Shutdown(); err != nil {
@@ -185,4 +371,130 @@ func main() {
 	log.Indent()
 	log.Infof("Total %d feed sources", len(conf.Rss.Sources))
 	log.Infof("Total %d new items (error=%d)", totalItems, totalItemErrors)
+	if totalUnfetched > 0 {
+		log.Warnf("Total %d served contents are not fetched", totalUnfetched)
+	}
+	if rl, ok := pc.RateLimit(); ok {
+		log.Infof("Pocket remaining quota: %s", rl)
+	}
+}
+
+// checkSinks checks that the sinks of all rss sources are configured.
+func checkSinks(conf feed.Config, sinks map[string]sink.Sink) error {
+	if _, ok := sinks[cmp.Or(conf.Sink, "pocket")]; !ok {
+		return fmt.Errorf("rss.sink: unknown or not configured sink: %s", conf.Sink)
+	}
+	for sid, src := range conf.Sources {
+		if _, ok := sinks[cmp.Or(src.Sink, conf.Sink, "pocket")]; !ok {
+			return fmt.Errorf("rss.sources.%s.sink: unknown or not configured sink: %s", sid, src.Sink)
+		}
+	}
+	return nil
+}
+
+// waitFetched waits until the served content of each index is fetched once, the timeout or the context is done.
+// It returns the indexes of which content is not fetched.
+func waitFetched(ctx context.Context, indexes []int, content func(int) *http_server.Content, timeout time.Duration) []int {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	fetched := make([]bool, len(indexes))
+	var syncAll sync.WaitGroup
+	for k, idx := range indexes {
+		sc := content(idx)
+		syncAll.Add(1)
+		go func() {
+			defer syncAll.Done()
+			select {
+			case <-sc.Done:
+				fetched[k] = true
+			case <-ctx.Done():
+			}
+		}()
+	}
+	syncAll.Wait()
+
+	unfetched := make([]int, 0)
+	for k, idx := range indexes {
+		if !fetched[k] {
+			unfetched = append(unfetched, idx)
+		}
+	}
+	return unfetched
+}
+
+// serve runs the content server for stored contents of previous runs until interrupted.
+// Expired contents are removed periodically by the server.
+func serve(conf Config) {
+	hc := util.Must1(http_server.NewServer(conf.Main.HttpServer, conf.Main.DataDir))("starting content server")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	<-ctx.Done()
+	log.Info("Received signal. Shutting down")
+	if err := hc.Shutdown(); err != nil {
+		log.Errorf("%s", err)
+	}
+}
+
+// Longest wait for the user to authorize in a web browser
+const authorizeTimeout = 10 * time.Minute
+
+// authorize obtains a Pocket access token by OAuth and saves it to a token file.
+func authorize(conf Config) {
+	pc := util.Must1(pocket.NewClient(conf.Pocket, conf.Main.DataDir))("creating Pocket client")
+
+	// Start content server to receive OAuth callback
+	hc := util.Must1(http_server.NewServer(conf.Main.HttpServer, conf.Main.DataDir))("starting content server")
+	defer func() {
+		if err := hc.Shutdown(); err != nil {
+			log.Errorf("%s", err)
+		}
+	}()
+	authorized := make(chan struct{}, 1)
+	hc.Handle("GET /auth/callback", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "feed-to-pocket is authorized. You can close this page.")
+		select {
+		case authorized <- struct{}{}:
+		default:
+		}
+	})
+	redirectUri := hc.Url("auth", "callback")
+
+	requestToken := util.Must1(pc.RequestToken(redirectUri))("requesting Pocket request token")
+	log.Info("Open this url in a web browser to authorize feed-to-pocket:")
+	log.Indent()
+	log.Infof("%s", pc.AuthorizeUrl(requestToken, redirectUri))
+	log.Unindent()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, authorizeTimeout)
+	defer cancel()
+	select {
+	case <-authorized:
+	case <-ctx.Done():
+		err := errors.New("interrupted")
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("not authorized in %s", authorizeTimeout)
+		}
+		// Callback server is shut down by the deferred function
+		util.Must(err)("waiting for Pocket authorization")
+	}
+
+	accessToken, username := util.Must2(pc.Authorize(requestToken))("authorizing Pocket access token")
+	log.Infof("Authorized Pocket user: %s", username)
+
+	// Save token to the file of access_token or in data directory
+	tokenFile, isFile := strings.CutPrefix(conf.Pocket.AccessToken, "file:")
+	if !isFile {
+		tokenFile = filepath.Join(conf.Main.DataDir, "pocket_access_token")
+	}
+	util.Must(os.MkdirAll(filepath.Dir(tokenFile), 0750))("creating token file directory")
+	util.Must(os.WriteFile(tokenFile, []byte(accessToken+"\n"), 0600))("writing token file")
+	log.Infof("Saved access token to %s", tokenFile)
+	if !isFile {
+		log.Infof("Set pocket.access_token = \"file:%s\" in the config file to use it", tokenFile)
+	}
 }
@@ -0,0 +1,249 @@
+//
+// fakepocket.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+// Package fakepocket is a local stand-in of Pocket API for integration tests and staging.
+// It accepts any consumer key and access token, and records all actions it receives.
+package fakepocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	RequestToken = "fake-request-token"
+	AccessToken  = "fake-access-token"
+	Username     = "fake-user"
+)
+
+// Rate limit quota reported in X-Limit-* headers
+const rateLimit = 10000
+
+// Action is an action received by /v3/send.
+type Action struct {
+	Action     string    `json:"action"`
+	Url        string    `json:"url,omitempty"`
+	Title      string    `json:"title,omitempty"`
+	Time       int64     `json:"time,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// item is an item in the fake Pocket list.
+type item struct {
+	ItemId    string `json:"item_id"`
+	GivenUrl  string `json:"given_url"`
+	Title     string `json:"given_title,omitempty"`
+	Status    string `json:"status"`
+	updatedAt int64
+}
+
+type Server struct {
+	mux     *http.ServeMux
+	mu      sync.Mutex
+	actions []Action
+	items   []*item
+	// Urls to fail when they are added
+	failUrls map[string]bool
+	calls    int
+}
+
+func New() *Server {
+	s := &Server{
+		mux:      http.NewServeMux(),
+		failUrls: make(map[string]bool),
+	}
+	s.mux.HandleFunc("POST /v3/send", s.handleSend)
+	s.mux.HandleFunc("POST /v3/get", s.handleGet)
+	s.mux.HandleFunc("POST /v3/oauth/request", s.handleOAuthRequest)
+	s.mux.HandleFunc("POST /v3/oauth/authorize", s.handleOAuthAuthorize)
+	s.mux.HandleFunc("GET /auth/authorize", s.handleAuthorize)
+	s.mux.HandleFunc("GET /fake/actions", s.handleActions)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.calls++
+	calls := s.calls
+	s.mu.Unlock()
+
+	h := w.Header()
+	h.Set("X-Limit-User-Limit", strconv.Itoa(rateLimit))
+	h.Set("X-Limit-User-Remaining", strconv.Itoa(max(0, rateLimit-calls)))
+	h.Set("X-Limit-User-Reset", "3600")
+	h.Set("X-Limit-Key-Limit", strconv.Itoa(rateLimit))
+	h.Set("X-Limit-Key-Remaining", strconv.Itoa(max(0, rateLimit-calls)))
+	h.Set("X-Limit-Key-Reset", "3600")
+	s.mux.ServeHTTP(w, r)
+}
+
+// Actions returns all actions received so far in order.
+func (s *Server) Actions() []Action {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Action(nil), s.actions...)
+}
+
+// FailUrl makes adding the url fail with an action error.
+func (s *Server) FailUrl(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failUrls[url] = true
+}
+
+// Reset forgets all recorded actions and items.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actions = nil
+	s.items = nil
+	s.failUrls = make(map[string]bool)
+}
+
+// authorized checks the keys in the request body and writes error response if they are missing.
+func authorized(w http.ResponseWriter, consumerKey string, accessToken string) bool {
+	if consumerKey == "" || accessToken == "" {
+		w.Header().Set("X-Error", "Missing consumer key or access token")
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func writeJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ConsumerKey string   `json:"consumer_key"`
+		AccessToken string   `json:"access_token"`
+		Actions     []Action `json:"actions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("X-Error", fmt.Sprintf("Invalid request: %s", err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !authorized(w, body.ConsumerKey, body.AccessToken) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	results := make([]any, 0, len(body.Actions))
+	errs := make([]any, 0, len(body.Actions))
+	for _, action := range body.Actions {
+		action.ReceivedAt = now
+		s.actions = append(s.actions, action)
+
+		if action.Action != "add" || action.Url == "" || s.failUrls[action.Url] {
+			results = append(results, false)
+			errs = append(errs, map[string]any{
+				"message": "Invalid action",
+				"type":    "Bad Request",
+				"code":    400,
+			})
+			continue
+		}
+		it := &item{
+			ItemId:    strconv.Itoa(len(s.items) + 1),
+			GivenUrl:  action.Url,
+			Title:     action.Title,
+			Status:    "0",
+			updatedAt: now.Unix(),
+		}
+		s.items = append(s.items, it)
+		results = append(results, it)
+		errs = append(errs, nil)
+	}
+
+	writeJson(w, map[string]any{
+		"status":         1,
+		"action_results": results,
+		"action_errors":  errs,
+	})
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ConsumerKey string `json:"consumer_key"`
+		AccessToken string `json:"access_token"`
+		Since       int64  `json:"since"`
+		Count       int    `json:"count"`
+		Offset      int    `json:"offset"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.Header().Set("X-Error", fmt.Sprintf("Invalid request: %s", err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !authorized(w, body.ConsumerKey, body.AccessToken) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	matched := make([]*item, 0)
+	for _, it := range s.items {
+		if it.updatedAt >= body.Since {
+			matched = append(matched, it)
+		}
+	}
+	if body.Offset < len(matched) {
+		matched = matched[body.Offset:]
+	} else {
+		matched = nil
+	}
+	if body.Count > 0 && body.Count < len(matched) {
+		matched = matched[:body.Count]
+	}
+
+	// Pocket returns an empty array instead of object if there is no item
+	var list any = []any{}
+	if len(matched) > 0 {
+		m := make(map[string]*item, len(matched))
+		for _, it := range matched {
+			m[it.ItemId] = it
+		}
+		list = m
+	}
+	writeJson(w, map[string]any{
+		"status": 1,
+		"since":  time.Now().Unix(),
+		"list":   list,
+	})
+}
+
+func (s *Server) handleOAuthRequest(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, map[string]string{"code": RequestToken})
+}
+
+func (s *Server) handleOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, map[string]string{
+		"access_token": AccessToken,
+		"username":     Username,
+	})
+}
+
+// handleAuthorize authorizes immediately and redirects back to the app.
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, r.URL.Query().Get("redirect_uri"), http.StatusFound)
+}
+
+func (s *Server) handleActions(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, s.Actions())
+}
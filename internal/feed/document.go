@@ -0,0 +1,238 @@
+//
+// document.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package feed
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+)
+
+//go:embed document.html
+var documentTmplStr string
+
+var defaultSanitizer = newSanitizer(SanitizerConfig{})
+
+// Default document template. It also defines "article_view_filler" template to be used by custom templates.
+var defaultDocumentTmpl = template.Must(parseDocumentTemplate("", "", defaultSanitizer))
+
+// documentFuncs are functions available in document templates.
+func documentFuncs(s *sanitizer) template.FuncMap {
+	return template.FuncMap{
+		"date":      formatDate,
+		"sanitize":  s.Sanitize,
+		"striptags": stripTags,
+		"truncate":  truncate,
+	}
+}
+
+// parseDocumentTemplate parses the template text with the sanitizer. Empty text is the default template.
+// Custom templates are parsed along with the default template so they can use its "article_view_filler".
+func parseDocumentTemplate(name string, text string, s *sanitizer) (*template.Template, error) {
+	tmpl, err := template.New("document-template").Funcs(documentFuncs(s)).Parse(documentTmplStr)
+	if err != nil || text == "" {
+		return tmpl, err
+	}
+	return tmpl.New(name).Parse(text)
+}
+
+// documentData is the data of document template.
+type documentData struct {
+	// Feed item. Its fields e.g. .Title, .Link, .Description are accessible directly.
+	// They are escaped when rendered. Use sanitize function to render HTML.
+	*gofeed.Item
+	Source Source
+	// Main article extracted from the item link if full_text is enabled
+	FullText string
+	// Sanitized HTML of full text or description
+	Body template.HTML
+}
+
+func buildDocument(tmpl *template.Template, data documentData) (string, error) {
+	buf := new(bytes.Buffer)
+
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("executing document template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// BuildDocuments builds the documents of the items to serve for article view and returns the error of each item.
+// It is called only for items to be added so full text articles are not extracted for skipped items.
+// Full text articles are extracted concurrently by at most rss.concurrency workers.
+func BuildDocuments(ctx context.Context, logs log.Logger, source Source, items []*Item) []error {
+	errs := make([]error, len(items))
+	itemLogs := make([]log.Buffer, len(items))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(max(1, source.concurrency), len(items)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = buildItemDocument(ctx, &itemLogs[i], source, items[i])
+			}
+		}()
+	}
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Logs are written in order of the items
+	for i := range itemLogs {
+		itemLogs[i].FlushTo(logs)
+	}
+	return errs
+}
+
+func buildItemDocument(ctx context.Context, logs *log.Buffer, source Source, item *Item) error {
+	feedItem := cmp.Or(item.feedItem, &gofeed.Item{Title: item.Title, Link: item.Url})
+	data := documentData{Item: feedItem, Source: source}
+	if source.FullText {
+		logs.Verbosef("[%s] Extracting full text article", item.Id)
+		fullText, err := extractArticle(ctx, source, item.Url)
+		if err != nil {
+			logs.Warnf("[%s] Cannot extract full text. Use description instead: %s", item.Id, err)
+		}
+		data.FullText = fullText
+	}
+	data.Body = source.sanitizer.Sanitize(cmp.Or(data.FullText, feedItem.Description))
+	doc, err := buildDocument(source.documentTmpl, data)
+	if err != nil {
+		logs.Errorf("[%s] Error while building document: %s", item.Id, err)
+		return err
+	}
+	item.Document = doc
+	return nil
+}
+
+// loadDocumentTemplate parses the template file and checks it with an example item.
+func loadDocumentTemplate(path string, s *sanitizer) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading document template: %w", err)
+	}
+	tmpl, err := parseDocumentTemplate(path, string(data), s)
+	if err != nil {
+		return nil, fmt.Errorf("parsing document template: %w", err)
+	}
+
+	now := time.Now()
+	example := documentData{
+		Item: &gofeed.Item{
+			Title:           "Example",
+			Description:     "<p>Example description</p>",
+			Content:         "<p>Example content</p>",
+			Link:            "https://example.com/",
+			Published:       now.Format(time.RFC1123Z),
+			PublishedParsed: &now,
+			Author:          &gofeed.Person{Name: "Example"},
+			Categories:      []string{"example"},
+		},
+		Source:   Source{Id: "example", Name: "Example"},
+		FullText: "<p>Example full text</p>",
+		Body:     "<p>Example full text</p>",
+	}
+	if _, err := buildDocument(tmpl, example); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// Validate validates the config and loads the sanitizer, document templates and filter rules.
+func (config *Config) Validate() error {
+	if err := compileRules("include", config.Include); err != nil {
+		return err
+	}
+	if err := compileRules("exclude", config.Exclude); err != nil {
+		return err
+	}
+	for sid, src := range config.Sources {
+		if err := compileRules("sources."+sid+".include", src.Include); err != nil {
+			return err
+		}
+		if err := compileRules("sources."+sid+".exclude", src.Exclude); err != nil {
+			return err
+		}
+	}
+
+	config.sanitizer = newSanitizer(config.Sanitizer)
+	config.templates = make(map[string]*template.Template)
+	tmpl, err := parseDocumentTemplate("", "", config.sanitizer)
+	if err != nil {
+		return fmt.Errorf("parsing default document template: %w", err)
+	}
+	config.templates[""] = tmpl
+
+	paths := []string{config.DocumentTemplate}
+	for _, src := range config.Sources {
+		paths = append(paths, src.DocumentTemplate)
+	}
+	for _, path := range paths {
+		if config.templates[path] != nil {
+			continue
+		}
+		tmpl, err := loadDocumentTemplate(path, config.sanitizer)
+		if err != nil {
+			return fmt.Errorf("document_template %s: %w", path, err)
+		}
+		config.templates[path] = tmpl
+	}
+	return nil
+}
+
+// formatDate formats the time with the layout. Time can be time.Time or *time.Time. Nil time is empty.
+func formatDate(layout string, t any) (string, error) {
+	switch v := t.(type) {
+	case time.Time:
+		return v.Format(layout), nil
+	case *time.Time:
+		if v == nil {
+			return "", nil
+		}
+		return v.Format(layout), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("date: unsupported time %T", t)
+	}
+}
+
+// stripTags returns the text content of the HTML.
+func stripTags(s string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(doc.Text()), nil
+}
+
+// truncate shortens the text to at most length characters with an ellipsis.
+func truncate(length int, s string) string {
+	if utf8.RuneCountInString(s) <= length {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:max(0, length-1)])) + "…"
+}
@@ -0,0 +1,119 @@
+//
+// document_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package feed
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+)
+
+// articleServer serves an article of the request path.
+func articleServer(t *testing.T, requests *atomic.Int32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		text := strings.Repeat(fmt.Sprintf("Article text of %s, which is long enough. ", r.URL.Path), 10)
+		fmt.Fprintf(w, `<html><body><nav>Menu</nav><article><p>%s</p></article></body></html>`, text)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func fullTextSource(concurrency int) Source {
+	timeout := 10 * time.Second
+	return Source{
+		Id:               "test",
+		ForceArticleView: true,
+		FullText:         true,
+		Timeout:          &timeout,
+		documentTmpl:     defaultDocumentTmpl,
+		sanitizer:        defaultSanitizer,
+		concurrency:      concurrency,
+	}
+}
+
+func testItem(link string) *Item {
+	return &Item{
+		Id:       link,
+		Url:      link,
+		Title:    "Title",
+		feedItem: &gofeed.Item{Title: "Title", Link: link, Description: "Description of the item"},
+	}
+}
+
+func TestBuildDocumentsFullText(t *testing.T) {
+	articleClient = http.DefaultClient
+	t.Cleanup(func() { articleClient = defaultArticleClient })
+
+	var requests atomic.Int32
+	srv := articleServer(t, &requests)
+	items := []*Item{testItem(srv.URL + "/a"), testItem(srv.URL + "/b"), testItem(srv.URL + "/c")}
+	errs := BuildDocuments(context.Background(), &log.Buffer{}, fullTextSource(2), items)
+
+	for i, item := range items {
+		if errs[i] != nil {
+			t.Fatalf("item %d: %s", i, errs[i])
+		}
+		path := item.Url[len(srv.URL):]
+		if !strings.Contains(item.Document, "Article text of "+path) {
+			t.Errorf("item %d: full text is not in document: %s", i, item.Document)
+		}
+		if strings.Contains(item.Document, "Menu") {
+			t.Errorf("item %d: navigation is in document: %s", i, item.Document)
+		}
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+}
+
+func TestBuildDocumentsRejectsLocalArticle(t *testing.T) {
+	var requests atomic.Int32
+	srv := articleServer(t, &requests)
+	items := []*Item{testItem(srv.URL + "/a")}
+	var logs log.Buffer
+	errs := BuildDocuments(context.Background(), &logs, fullTextSource(1), items)
+
+	if errs[0] != nil {
+		t.Fatal(errs[0])
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("article on loopback address is downloaded %d times", n)
+	}
+	if !strings.Contains(items[0].Document, "Description of the item") {
+		t.Errorf("description is not used instead: %s", items[0].Document)
+	}
+}
+
+func TestBuildDocumentsWithoutFullText(t *testing.T) {
+	var requests atomic.Int32
+	srv := articleServer(t, &requests)
+	source := fullTextSource(1)
+	source.FullText = false
+	items := []*Item{testItem(srv.URL + "/a")}
+	errs := BuildDocuments(context.Background(), &log.Buffer{}, source, items)
+
+	if errs[0] != nil {
+		t.Fatal(errs[0])
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("article is downloaded %d times without full_text", n)
+	}
+	if !strings.Contains(items[0].Document, "Description of the item") {
+		t.Errorf("description is not in document: %s", items[0].Document)
+	}
+}
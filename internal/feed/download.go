@@ -0,0 +1,175 @@
+//
+// download.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package feed
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+	"github.com/teerapap/feed-to-pocket/internal/util"
+)
+
+// Longest Retry-After the downloader is willing to wait for
+const maxRetryAfter = 5 * time.Minute
+
+// httpCache keeps the validators of the last saved feed for conditional GET.
+type httpCache struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func readHttpCache(path string) (httpCache, error) {
+	var cache httpCache
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cache, nil
+		}
+		return cache, err
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return cache, fmt.Errorf("parsing http cache file: %w", err)
+	}
+	return cache, nil
+}
+
+func (c httpCache) save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0640)
+}
+
+// downloadFile downloads the source url into the file.
+// It retries with exponential backoff on network errors, 429 and 5xx responses.
+// It returns nil cache if the content is not modified since the given cache.
+func downloadFile(ctx context.Context, logs *log.Buffer, source Source, cache httpCache, file *os.File) (*httpCache, error) {
+	header, err := requestHeader(source)
+	if err != nil {
+		return nil, fmt.Errorf("preparing request headers: %w", err)
+	}
+	client := &http.Client{Timeout: *source.Timeout}
+	backoff := *source.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		newCache, err := download(ctx, client, source.Url, header, cache, file)
+		if err == nil {
+			return newCache, nil
+		}
+
+		var aErr *util.AttemptError
+		if !errors.As(err, &aErr) || !aErr.Retryable || attempt >= *source.Retries {
+			return nil, err
+		}
+		wait := backoff
+		if aErr.RetryAfter > 0 {
+			if aErr.RetryAfter > maxRetryAfter {
+				return nil, fmt.Errorf("%w (retry after %s is too long)", err, aErr.RetryAfter)
+			}
+			wait = aErr.RetryAfter
+		}
+		logs.Warnf("Download failed: %s. Retrying in %s (%d/%d)", err, wait, attempt+1, *source.Retries)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff = backoff * 2
+	}
+}
+
+func download(ctx context.Context, client *http.Client, url string, header http.Header, cache httpCache, file *os.File) (*httpCache, error) {
+	// Reset file from previous attempts
+	if err := file.Truncate(0); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header.Clone()
+	if cache.ETag != "" {
+		req.Header.Set("If-None-Match", cache.ETag)
+	}
+	if cache.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cache.LastModified)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, &util.AttemptError{Err: err, Retryable: ctx.Err() == nil}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, &util.AttemptError{
+			Err:        fmt.Errorf("bad download status: %s", res.Status),
+			Retryable:  res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500,
+			RetryAfter: util.ParseRetryAfter(res.Header.Get("Retry-After")),
+		}
+	}
+
+	if _, err = io.Copy(file, res.Body); err != nil {
+		return nil, &util.AttemptError{Err: err, Retryable: true}
+	}
+	return &httpCache{
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// requestHeader builds the download request headers from the source options.
+func requestHeader(source Source) (http.Header, error) {
+	header := make(http.Header)
+	for key, value := range source.Headers {
+		v, err := util.ResolveValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", key, err)
+		}
+		header.Set(key, v)
+	}
+	if source.UserAgent != "" {
+		header.Set("User-Agent", source.UserAgent)
+	}
+	if source.BasicAuth != nil {
+		username, err := util.ResolveValue(source.BasicAuth.Username)
+		if err != nil {
+			return nil, fmt.Errorf("basic_auth.username: %w", err)
+		}
+		password, err := util.ResolveValue(source.BasicAuth.Password)
+		if err != nil {
+			return nil, fmt.Errorf("basic_auth.password: %w", err)
+		}
+		auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		header.Set("Authorization", "Basic "+auth)
+	}
+	if source.BearerToken != "" {
+		token, err := util.ResolveValue(source.BearerToken)
+		if err != nil {
+			return nil, fmt.Errorf("bearer_token: %w", err)
+		}
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return header, nil
+}
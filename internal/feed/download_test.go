@@ -0,0 +1,221 @@
+//
+// download_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package feed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>A</title><link>https://example.com/a</link><guid>a</guid></item>
+</channel></rss>`
+
+// feedServer serves the test feed with validators. It responds to the first requests with the statuses.
+type feedServer struct {
+	mu       sync.Mutex
+	statuses []int
+	header   http.Header // Headers of responses with the statuses
+	requests []*http.Request
+}
+
+func (s *feedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	status := 0
+	if len(s.statuses) > 0 {
+		status = s.statuses[0]
+		s.statuses = s.statuses[1:]
+	}
+	s.mu.Unlock()
+
+	if status != 0 {
+		for key, values := range s.header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(status)
+		return
+	}
+	if r.Header.Get("If-None-Match") == `"v1"` {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", `"v1"`)
+	w.Header().Set("Last-Modified", "Tue, 01 Oct 2024 12:00:00 GMT")
+	w.Write([]byte(testFeed))
+}
+
+func newTestSource(t *testing.T, s *feedServer, retries int) Source {
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	timeout := 10 * time.Second
+	backoff := time.Millisecond
+	return Source{Id: "test", Url: srv.URL, Timeout: &timeout, Retries: &retries, RetryBackoff: &backoff}
+}
+
+func testDownload(t *testing.T, source Source, cache httpCache) (*httpCache, error) {
+	file, err := os.CreateTemp(t.TempDir(), "rss-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	return downloadFile(context.Background(), &log.Buffer{}, source, cache, file)
+}
+
+func TestDownloadNotModified(t *testing.T) {
+	s := &feedServer{}
+	source := newTestSource(t, s, 0)
+
+	newCache, err := testDownload(t, source, httpCache{})
+	if err != nil {
+		t.Fatalf("downloading: %s", err)
+	}
+	want := httpCache{ETag: `"v1"`, LastModified: "Tue, 01 Oct 2024 12:00:00 GMT"}
+	if newCache == nil || *newCache != want {
+		t.Fatalf("cache = %+v, want %+v", newCache, want)
+	}
+
+	newCache, err = testDownload(t, source, want)
+	if err != nil || newCache != nil {
+		t.Errorf("download with cache = %+v, %v, want not modified", newCache, err)
+	}
+	r := s.requests[1]
+	if r.Header.Get("If-None-Match") != want.ETag || r.Header.Get("If-Modified-Since") != want.LastModified {
+		t.Errorf("conditional request headers = %v, want validators of the cache", r.Header)
+	}
+}
+
+func TestFetchFeedNotModifiedKeepsCache(t *testing.T) {
+	s := &feedServer{}
+	source := newTestSource(t, s, 0)
+	dir := t.TempDir()
+	cache := httpCache{ETag: `"v1"`, LastModified: "Tue, 01 Oct 2024 12:00:00 GMT"}
+	if err := cache.save(filepath.Join(dir, "http_cache.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	f := fetchFeed(context.Background(), source, dir)
+	defer f.close()
+	if f.err != nil {
+		t.Fatalf("fetching feed: %s", f.err)
+	}
+	if f.feed != nil {
+		t.Errorf("feed is parsed, want not modified")
+	}
+	if f.cache != cache {
+		t.Errorf("cache = %+v, want the saved cache %+v", f.cache, cache)
+	}
+}
+
+func TestDownloadRetryAfter(t *testing.T) {
+	s := &feedServer{statuses: []int{http.StatusTooManyRequests}, header: http.Header{"Retry-After": {"1"}}}
+	source := newTestSource(t, s, 1)
+
+	start := time.Now()
+	newCache, err := testDownload(t, source, httpCache{})
+	if err != nil || newCache == nil {
+		t.Fatalf("download = %+v, %v, want success after retry", newCache, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want Retry-After of 1s", elapsed)
+	}
+	if len(s.requests) != 2 {
+		t.Errorf("got %d requests, want 2", len(s.requests))
+	}
+}
+
+func TestDownloadRetryAfterTooLong(t *testing.T) {
+	s := &feedServer{statuses: []int{http.StatusTooManyRequests}, header: http.Header{"Retry-After": {"3600"}}}
+	source := newTestSource(t, s, 3)
+
+	if _, err := testDownload(t, source, httpCache{}); err == nil {
+		t.Errorf("download succeeded, want error")
+	}
+	if len(s.requests) != 1 {
+		t.Errorf("got %d requests, want 1", len(s.requests))
+	}
+}
+
+func TestDownloadRetryServerError(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		wantErr  bool
+		requests int
+	}{
+		{"retry then succeed", []int{http.StatusServiceUnavailable, http.StatusInternalServerError}, 2, false, 3},
+		{"retries exhausted", []int{500, 502, 503, 504}, 2, true, 3},
+		{"no retries", []int{http.StatusBadGateway}, 0, true, 1},
+		{"no retry on client error", []int{http.StatusNotFound}, 2, true, 1},
+	}
+	for _, tt := range tests {
+		s := &feedServer{statuses: tt.statuses}
+		source := newTestSource(t, s, tt.retries)
+		_, err := testDownload(t, source, httpCache{})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: download error = %v, want error %t", tt.name, err, tt.wantErr)
+		}
+		if len(s.requests) != tt.requests {
+			t.Errorf("%s: got %d requests, want %d", tt.name, len(s.requests), tt.requests)
+		}
+	}
+}
+
+func TestDownloadRequestHeaders(t *testing.T) {
+	t.Setenv("FEED_TEST_TOKEN", "env-token")
+	tokenFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(tokenFile, []byte("file-password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &feedServer{}
+	source := newTestSource(t, s, 0)
+	source.Headers = map[string]string{"X-Plain": "plain", "X-Env": "env:FEED_TEST_TOKEN", "X-File": "file:" + tokenFile}
+	source.UserAgent = "test-agent"
+	source.BasicAuth = &BasicAuth{Username: "user", Password: "file:" + tokenFile}
+	if _, err := testDownload(t, source, httpCache{}); err != nil {
+		t.Fatalf("downloading: %s", err)
+	}
+
+	r := s.requests[0]
+	for key, want := range map[string]string{"X-Plain": "plain", "X-Env": "env-token", "X-File": "file-password", "User-Agent": "test-agent"} {
+		if got := r.Header.Get(key); got != want {
+			t.Errorf("header %s = %q, want %q", key, got, want)
+		}
+	}
+	if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "file-password" {
+		t.Errorf("basic auth = %s:%s, want user:file-password", username, password)
+	}
+
+	source.BasicAuth = nil
+	source.BearerToken = "env:FEED_TEST_TOKEN"
+	if _, err := testDownload(t, source, httpCache{}); err != nil {
+		t.Fatalf("downloading: %s", err)
+	}
+	if got := s.requests[1].Header.Get("Authorization"); got != "Bearer env-token" {
+		t.Errorf("authorization = %q, want bearer token", got)
+	}
+
+	source.BearerToken = "env:FEED_TEST_MISSING"
+	if _, err := testDownload(t, source, httpCache{}); err == nil {
+		t.Errorf("download with missing environment variable succeeded, want error")
+	}
+	if len(s.requests) != 2 {
+		t.Errorf("got %d requests, want no request with unresolved headers", len(s.requests))
+	}
+}
@@ -0,0 +1,202 @@
+//
+// extract.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package feed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+	"github.com/teerapap/feed-to-pocket/internal/util"
+)
+
+// Largest article page to download
+const maxArticleSize = 5 * 1024 * 1024
+
+// Shortest extracted text to be considered as the article
+const minArticleLength = 250
+
+var (
+	// Elements that are never part of the article
+	removedTags = "script, style, noscript, iframe, form, nav, header, footer, aside, svg, button, input, select, textarea"
+	// Class or id of elements unlikely to be the article
+	unlikelyRe = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|disqus|extra|foot|header|menu|related|remark|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|ad-break|agegate|pagination|pager|popup|promo|newsletter|subscribe`)
+	// Class or id of elements which may be the article even if they look unlikely
+	maybeRe = regexp.MustCompile(`(?i)and|article|body|column|main|shadow`)
+	// Class or id weights of candidates
+	positiveRe = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativeRe = regexp.MustCompile(`(?i)hidden|banner|combx|comment|com-|contact|foot|footer|footnote|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|widget`)
+)
+
+// Client to download articles. Links of feed items must not reach the host network.
+var defaultArticleClient = util.PublicClient(0)
+
+var articleClient = defaultArticleClient
+
+// extractArticle downloads the page of the link and extracts the main article in HTML.
+func extractArticle(ctx context.Context, source Source, link string) (string, error) {
+	if *source.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *source.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		return "", err
+	}
+	// Only user agent is sent. Other source headers are credentials of the feed.
+	if source.UserAgent != "" {
+		req.Header.Set("User-Agent", source.UserAgent)
+	}
+	res, err := articleClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("downloading article: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad download status: %s", res.Status)
+	}
+
+	body, err := charset.NewReader(io.LimitReader(res.Body, maxArticleSize), res.Header.Get("Content-Type"))
+	if err != nil {
+		return "", fmt.Errorf("detecting charset: %w", err)
+	}
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return "", fmt.Errorf("parsing article: %w", err)
+	}
+
+	// Resolve relative links against the final url after redirects
+	return extractMainContent(doc, res.Request.URL)
+}
+
+// extractMainContent finds the element with the most paragraph text by a readability-style scoring.
+func extractMainContent(doc *goquery.Document, base *url.URL) (string, error) {
+	doc.Find(removedTags).Remove()
+	doc.Find("*").Each(func(_ int, s *goquery.Selection) {
+		if s.Is("html, body, article, main") {
+			return
+		}
+		match := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+		if unlikelyRe.MatchString(match) && !maybeRe.MatchString(match) {
+			s.Remove()
+		}
+	})
+
+	// Score parents of paragraphs by their text
+	scores := make(map[*html.Node]float64)
+	candidates := make([]*goquery.Selection, 0)
+	addScore := func(s *goquery.Selection, score float64) {
+		if s.Length() == 0 {
+			return
+		}
+		node := s.Get(0)
+		if _, ok := scores[node]; !ok {
+			scores[node] = initialScore(s)
+			candidates = append(candidates, s)
+		}
+		scores[node] = scores[node] + score
+	}
+	doc.Find("p, pre, td, blockquote").Each(func(_ int, s *goquery.Selection) {
+		text := strings.TrimSpace(s.Text())
+		length := utf8.RuneCountInString(text)
+		if length < 25 {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + min(float64(length)/100, 3)
+		addScore(s.Parent(), score)
+		addScore(s.Parent().Parent(), score/2)
+	})
+
+	// Pick the best candidate adjusted by its link density
+	var best *goquery.Selection
+	bestScore := 0.0
+	for _, s := range candidates {
+		score := scores[s.Get(0)] * (1 - linkDensity(s))
+		if best == nil || score > bestScore {
+			best = s
+			bestScore = score
+		}
+	}
+	if best == nil {
+		return "", fmt.Errorf("no article is found")
+	}
+	if length := utf8.RuneCountInString(strings.TrimSpace(best.Text())); length < minArticleLength {
+		return "", fmt.Errorf("article is too short (%d characters)", length)
+	}
+
+	absolutizeUrls(best, base)
+	return best.Html()
+}
+
+func initialScore(s *goquery.Selection) float64 {
+	score := 0.0
+	switch goquery.NodeName(s) {
+	case "article", "main":
+		score = 10
+	case "div":
+		score = 5
+	case "pre", "td", "blockquote":
+		score = 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score = -3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score = -5
+	}
+	for _, attr := range []string{"class", "id"} {
+		value := s.AttrOr(attr, "")
+		if value == "" {
+			continue
+		}
+		if negativeRe.MatchString(value) {
+			score = score - 25
+		}
+		if positiveRe.MatchString(value) {
+			score = score + 25
+		}
+	}
+	return score
+}
+
+// linkDensity is the ratio of link text to all text in the element.
+func linkDensity(s *goquery.Selection) float64 {
+	length := utf8.RuneCountInString(s.Text())
+	if length == 0 {
+		return 0
+	}
+	linkLength := 0
+	s.Find("a").Each(func(_ int, a *goquery.Selection) {
+		linkLength = linkLength + utf8.RuneCountInString(a.Text())
+	})
+	return float64(linkLength) / float64(length)
+}
+
+// absolutizeUrls resolves relative links and image sources so they work in the served document.
+func absolutizeUrls(s *goquery.Selection, base *url.URL) {
+	for _, attr := range []string{"href", "src"} {
+		s.Find("[" + attr + "]").Each(func(_ int, e *goquery.Selection) {
+			ref, err := url.Parse(e.AttrOr(attr, ""))
+			if err != nil {
+				log.Verbosef("Invalid %s in article: %s", attr, err)
+				return
+			}
+			e.SetAttr(attr, base.ResolveReference(ref).String())
+		})
+	}
+}
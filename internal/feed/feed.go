@@ -8,16 +8,15 @@
 package feed
 
 import (
-	"bytes"
-	_ "embed"
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"html/template"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
-	"text/template"
 	"time"
 
 	"github.com/mmcdole/gofeed"
@@ -25,30 +24,93 @@ import (
 )
 
 type Config struct {
-	StartDate time.Time         `toml:"start_date"`
-	Sources   map[string]Source `toml:"sources"`
+	StartDate        time.Time         `toml:"start_date"`
+	DocumentTemplate string            `toml:"document_template,omitempty"`
+	Concurrency      int               `toml:"concurrency,omitempty"`
+	Timeout          *time.Duration    `toml:"timeout,omitempty"`
+	Retries          int               `toml:"retries,omitempty"`
+	RetryBackoff     *time.Duration    `toml:"retry_backoff,omitempty"`
+	Interval         time.Duration     `toml:"interval,omitempty"`
+	Sink             string            `toml:"sink,omitempty"`
+	Sources          map[string]Source `toml:"sources"`
+
+	// Item filters of all sources
+	Include []Rule `toml:"include,omitempty"`
+	Exclude []Rule `toml:"exclude,omitempty"`
+
+	// HTML allow-list of feed content in documents
+	Sanitizer SanitizerConfig `toml:"sanitizer,omitempty"`
+
+	// Loaded by Validate
+	sanitizer *sanitizer
+	templates map[string]*template.Template // by path
 }
 
 type Source struct {
-	Id               string    `toml:"-"`
-	Name             string    `toml:"name"`
-	Url              string    `toml:"url"`
-	ForceArticleView bool      `toml:"force_article_view"`
-	StartDate        time.Time `toml:"start_date,omitempty"`
+	Id               string        `toml:"-"`
+	Name             string        `toml:"name"`
+	Url              string        `toml:"url"`
+	ForceArticleView bool          `toml:"force_article_view"`
+	FullText         bool          `toml:"full_text,omitempty"`
+	DocumentTemplate string        `toml:"document_template,omitempty"`
+	StartDate        time.Time     `toml:"start_date,omitempty"`
+	Interval         time.Duration `toml:"interval,omitempty"`
+	Sink             string        `toml:"sink,omitempty"`
+
+	// Download timeout and retries. Nil means the global value. Zero timeout means no timeout.
+	Timeout      *time.Duration `toml:"timeout,omitempty"`
+	Retries      *int           `toml:"retries,omitempty"`
+	RetryBackoff *time.Duration `toml:"retry_backoff,omitempty"`
+
+	// Download request options. Values can be loaded with "env:NAME" or "file:PATH".
+	Headers     map[string]string `toml:"headers,omitempty"`
+	UserAgent   string            `toml:"user_agent,omitempty"`
+	BasicAuth   *BasicAuth        `toml:"basic_auth,omitempty"`
+	BearerToken string            `toml:"bearer_token,omitempty"`
+
+	// Item filters. Items matching any exclude rule are dropped.
+	// If there are include rules, only items matching any of them are kept.
+	// Exclude rules are added to the global ones. Include rules replace the global ones.
+	Include []Rule `toml:"include,omitempty"`
+	Exclude []Rule `toml:"exclude,omitempty"`
+
+	documentTmpl *template.Template
+	sanitizer    *sanitizer
+	concurrency  int // of full text extraction
 }
 
+type BasicAuth struct {
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+}
+
+const (
+	defaultTimeout      = 30 * time.Second
+	defaultRetryBackoff = 1 * time.Second
+	defaultInterval     = 30 * time.Minute
+)
+
 type Item struct {
 	Id       string
+	Guid     string
 	Url      string
 	Title    string
 	Time     time.Time
 	Tags     []string
-	Document string
+	Document string // to serve if the source forces article view. It is built by BuildDocuments.
+
+	feedItem *gofeed.Item
 }
 
-type NewItemConsumer = func([]Item, Source) (bool, error)
+// NewItemConsumer delivers new items of the source.
+// It returns the delivery error of each item in the same order, or nil slice if the items are not delivered at all (e.g. dry-run).
+// Error means all items failed.
+// In daemon mode (Poll), it may be called concurrently for different sources.
+// Logs of the delivery should be written to the logger to keep them together with the other logs of the source.
+type NewItemConsumer = func([]Item, Source, log.Logger) ([]error, error)
 
-func FindNewItems(config Config, dataDir string, consumer NewItemConsumer) {
+// sortedSources returns the sources sorted by id with default values from the config.
+func (config Config) sortedSources() []Source {
 	// Sort sources by id
 	ids := make([]string, 0, len(config.Sources))
 	for sid := range config.Sources {
@@ -56,142 +118,278 @@ func FindNewItems(config Config, dataDir string, consumer NewItemConsumer) {
 	}
 	sort.Strings(ids)
 
-	// For each source
+	sources := make([]Source, 0, len(ids))
 	for _, sid := range ids {
 		src := config.Sources[sid]
 		if src.StartDate.IsZero() {
 			src.StartDate = config.StartDate
 		}
+		// Explicit zero values of the source override the global values
+		src.Timeout = cmp.Or(src.Timeout, config.Timeout, ptr(defaultTimeout))
+		src.Retries = cmp.Or(src.Retries, ptr(config.Retries))
+		src.RetryBackoff = cmp.Or(src.RetryBackoff, config.RetryBackoff, ptr(defaultRetryBackoff))
+		if src.Interval <= 0 {
+			src.Interval = cmp.Or(config.Interval, defaultInterval)
+		}
+		if src.Sink == "" {
+			src.Sink = config.Sink
+		}
+		if src.DocumentTemplate == "" {
+			src.DocumentTemplate = config.DocumentTemplate
+		}
+		if len(src.Include) == 0 {
+			src.Include = config.Include
+		}
+		src.Exclude = slices.Concat(config.Exclude, src.Exclude)
+		src.documentTmpl = cmp.Or(config.templates[src.DocumentTemplate], defaultDocumentTmpl)
+		src.sanitizer = cmp.Or(config.sanitizer, defaultSanitizer)
+		src.concurrency = max(1, config.Concurrency)
 		src.Id = sid
+		sources = append(sources, src)
+	}
+	return sources
+}
 
-		log.Printf("Processing rss source (%s)", src.Id)
-		// Create rss source data directory
-		dir := filepath.Join(dataDir, "rss", src.Id)
-		if err := os.MkdirAll(dir, 0750); err != nil {
-			log.Errorf("creating rss source(%s) directory: %s", src.Id, err)
-		}
+func ptr[T any](v T) *T {
+	return &v
+}
 
-		// Find new items from this source
-		err := findNewItems(src, dir, consumer)
-		if err != nil {
-			log.Errorf("processing rss source(%s): %s", src.Id, err)
+// FindNewItems finds new items from all sources and passes them to the consumer in order.
+// When the context is done, the remaining sources are skipped but the in-progress consumer is not interrupted.
+func FindNewItems(ctx context.Context, config Config, dataDir string, consumer NewItemConsumer) {
+	sources := config.sortedSources()
+
+	// Download and parse feeds in parallel by a bounded worker pool
+	concurrency := max(1, config.Concurrency)
+	fetched := make([]chan *fetchedFeed, len(sources))
+	for i := range fetched {
+		fetched[i] = make(chan *fetchedFeed, 1)
+	}
+	jobs := make(chan int)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			for i := range jobs {
+				fetched[i] <- fetchFeed(ctx, sources[i], sourceDir(dataDir, sources[i]))
+			}
+		}()
+	}
+	go func() {
+		for i := range sources {
+			jobs <- i
 		}
+		close(jobs)
+	}()
+
+	// For each source in order
+	for i, src := range sources {
+		processSource(ctx, log.Std, src, dataDir, <-fetched[i], consumer)
 	}
 }
 
-func findNewItems(source Source, dir string, consumer NewItemConsumer) error {
-	log.Indent()
-	defer log.Unindent()
+// processSource finds new items from the fetched feed of the source and passes them to the consumer.
+func processSource(ctx context.Context, logs log.Logger, src Source, dataDir string, f *fetchedFeed, consumer NewItemConsumer) {
+	defer f.close()
+	if ctx.Err() != nil {
+		logs.Verbosef("Skip processing rss source (%s) because of shutdown", src.Id)
+		return
+	}
+	logs.Printf("Processing rss source (%s)", src.Id)
 
-	rssPath := filepath.Join(dir, "feed.xml")
+	// Find new items from this source
+	if err := findNewItems(ctx, logs, src, sourceDir(dataDir, src), f, consumer); err != nil {
+		logs.Errorf("processing rss source(%s): %s", src.Id, err)
+	}
+}
+
+func sourceDir(dataDir string, src Source) string {
+	return filepath.Join(dataDir, "rss", src.Id)
+}
+
+// fetchedFeed is the downloaded and parsed new feed of a source.
+type fetchedFeed struct {
+	logs    log.Buffer
+	tmpFile *os.File
+	feed    *gofeed.Feed // nil if not modified
+	cache   httpCache
+	err     error
+}
+
+// fetchFeed downloads and parses the new feed of the source.
+// It is safe to be called concurrently. Logs are kept in the buffer to be written later in order.
+func fetchFeed(ctx context.Context, source Source, dir string) *fetchedFeed {
+	f := &fetchedFeed{}
+	if err := ctx.Err(); err != nil {
+		f.err = err
+		return f
+	}
+
+	// Create rss source data directory
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		f.logs.Errorf("creating rss source(%s) directory: %s", source.Id, err)
+	}
 
-	// Read old feed
-	oldFeed, err := readOldFeed(rssPath)
+	// Read HTTP cache validators of the last saved feed
+	cache, err := readHttpCache(filepath.Join(dir, "http_cache.json"))
 	if err != nil {
-		return fmt.Errorf("reading old rss file: %w", err)
+		f.err = fmt.Errorf("reading http cache file: %w", err)
+		return f
 	}
 
 	// Create tmp file for new feed
-	tmpFile, err := os.CreateTemp("", "rss-")
+	f.tmpFile, err = os.CreateTemp("", "rss-")
 	if err != nil {
-		return fmt.Errorf("creating temp file: %w", err)
+		f.err = fmt.Errorf("creating temp file: %w", err)
+		return f
 	}
-	defer os.Remove(tmpFile.Name()) // clean up
-	defer tmpFile.Close()
 
 	// Read new feed
-	newFeed, err := readNewFeed(source.Url, tmpFile)
+	f.feed, f.cache, err = readNewFeed(ctx, &f.logs, source, cache, f.tmpFile)
+	if err != nil {
+		f.err = fmt.Errorf("reading new rss file: %w", err)
+	}
+	return f
+}
+
+func (f *fetchedFeed) close() {
+	if f.tmpFile != nil {
+		f.tmpFile.Close()
+		os.Remove(f.tmpFile.Name()) // clean up
+	}
+}
+
+func findNewItems(ctx context.Context, logs log.Logger, source Source, dir string, fetched *fetchedFeed, consumer NewItemConsumer) error {
+	logs.Indent()
+	defer logs.Unindent()
+
+	fetched.logs.FlushTo(logs)
+	if fetched.err != nil {
+		return fetched.err
+	}
+	if fetched.feed == nil {
+		logs.Printf("Feed is not modified since last time")
+		logs.Printf("Found 0 new items")
+		return nil
+	}
+
+	rssPath := filepath.Join(dir, "feed.xml")
+	cachePath := filepath.Join(dir, "http_cache.json")
+
+	// Read seen-item database
+	db, err := openSeenDB(logs, dir, rssPath)
 	if err != nil {
-		return fmt.Errorf("reading new rss file: %w", err)
+		return fmt.Errorf("reading seen-item database: %w", err)
 	}
 
-	// Compare old vs new feed items
-	newItems := compareFeedItems(oldFeed, newFeed, source)
+	// Compare seen vs new feed items
+	newItems := compareFeedItems(logs, db, fetched.feed, source)
 
 	// Consume new items
-	log.Printf("Found %d new items", len(newItems))
-	saved, err := consumer(newItems, source)
+	logs.Printf("Found %d new items", len(newItems))
+	itemErrs, err := consumer(newItems, source, logs)
 	if err != nil {
+		// Record failed items so they are retried next time
+		recordItems(db, newItems, nil, err)
+		if err := db.save(); err != nil {
+			logs.Errorf("saving seen-item database: %s", err)
+		}
 		return fmt.Errorf("consuming new items: %w", err)
 	}
+	if itemErrs == nil {
+		return nil
+	}
+
+	// Only delivered items are seen. Failed items are retried next time.
+	failed := recordItems(db, newItems, itemErrs, nil)
+	logs.Printf("Saving seen-item database at %s", db.path)
+	if err := db.save(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		// Keep old feed and http cache so the feed is downloaded again next time
+		return fmt.Errorf("failed to deliver %d of %d new items", failed, len(newItems))
+	}
 
 	// Save new feed file
-	if saved {
-		log.Printf("Saving new feed file at %s", rssPath)
-		if err := os.Rename(tmpFile.Name(), rssPath); err != nil {
-			return fmt.Errorf("saving new rss file: %w", err)
-		}
+	logs.Printf("Saving new feed file at %s", rssPath)
+	if err := os.Rename(fetched.tmpFile.Name(), rssPath); err != nil {
+		return fmt.Errorf("saving new rss file: %w", err)
+	}
+	if err := fetched.cache.save(cachePath); err != nil {
+		return fmt.Errorf("saving http cache file: %w", err)
 	}
 
 	return nil
 }
 
-func compareFeedItems(oldFeed *gofeed.Feed, newFeed *gofeed.Feed, source Source) []Item {
-	if oldFeed != nil {
-		log.Printf("Comparing items - old=%d, new=%d", len(oldFeed.Items), len(newFeed.Items))
-	} else {
-		log.Printf("Comparing items - old=0, new=%d", len(newFeed.Items))
+// recordItems records the delivery status of items by their error, or the common error if itemErrs is nil.
+// It returns the number of failed items.
+func recordItems(db *seenDB, items []Item, itemErrs []error, err error) int {
+	now := time.Now()
+	failed := 0
+	for i, item := range items {
+		if itemErrs != nil {
+			err = itemErrs[i]
+		}
+		status := StatusDelivered
+		if err != nil {
+			status = StatusFailed
+			failed++
+		}
+		db.record(item.Guid, item.Url, status, now)
 	}
-	log.Indent()
-	defer log.Unindent()
+	return failed
+}
 
-	newItems := make([]Item, 0)
+func compareFeedItems(logs log.Logger, db *seenDB, newFeed *gofeed.Feed, source Source) []Item {
+	logs.Printf("Comparing items - seen=%d, new=%d", len(db.Items), len(newFeed.Items))
+	logs.Indent()
+	defer logs.Unindent()
 
-	guids := make(map[string]bool)
-	links := make(map[string]bool)
-	if oldFeed != nil {
-		for _, item := range oldFeed.Items {
-			guids[item.GUID] = item.GUID != ""
-			links[item.Link] = item.Link != ""
-		}
-	}
+	newItems := make([]Item, 0)
 
 	for _, item := range newFeed.Items {
 
 		if item.Link == "" {
-			log.Verbosef("[%s] Item has no link", item.GUID)
+			logs.Verbosef("[%s] Item has no link", item.GUID)
 			continue
 		}
 
 		output := Item{
-			Id:    item.Link,
-			Url:   item.Link,
-			Title: item.Title,
-			Tags:  []string{source.Id},
+			Id:       item.Link,
+			Guid:     item.GUID,
+			Url:      item.Link,
+			Title:    item.Title,
+			Tags:     []string{source.Id},
+			feedItem: item,
 		}
 
 		if item.PublishedParsed != nil {
 			if item.PublishedParsed.Before(source.StartDate) {
-				log.Verbosef("[%s] Item was published (%s) before start date (%s)", output.Id, item.PublishedParsed.UTC().Format(time.DateTime), source.StartDate.UTC().Format(time.DateTime))
+				logs.Verbosef("[%s] Item was published (%s) before start date (%s)", output.Id, item.PublishedParsed.UTC().Format(time.DateTime), source.StartDate.UTC().Format(time.DateTime))
 				continue
 			}
 			output.Time = *item.PublishedParsed
 		} else {
 			if item.UpdatedParsed != nil {
 				if item.UpdatedParsed.Before(source.StartDate) {
-					log.Verbosef("[%s] Item was updated (%s) before start date (%s)", output.Id, item.UpdatedParsed.UTC().Format(time.DateTime), source.StartDate.UTC().Format(time.DateTime))
+					logs.Verbosef("[%s] Item was updated (%s) before start date (%s)", output.Id, item.UpdatedParsed.UTC().Format(time.DateTime), source.StartDate.UTC().Format(time.DateTime))
 					continue
 				}
 				output.Time = *item.UpdatedParsed
 			}
 		}
 
-		if item.GUID != "" && guids[item.GUID] {
-			log.Verbosef("[%s] Item GUID matched in old feed - guid=%s", output.Id, item.GUID)
-			continue
-		}
-		if links[item.Link] {
-			log.Verbosef("[%s] Item link matched in old feed", output.Id)
+		if reason := filterItem(item, source); reason != "" {
+			logs.Verbosef("[%s] Item was filtered out - %s", output.Id, reason)
 			continue
 		}
 
-		if source.ForceArticleView {
-			doc, err := buildDocument(item)
-			if err != nil {
-				log.Errorf("[%s] Error while building document: %s", output.Id, err)
+		if seen := db.lookup(item.GUID, item.Link); seen != nil {
+			if seen.Status == StatusDelivered {
+				logs.Verbosef("[%s] Item was already delivered (first seen %s) - guid=%s", output.Id, seen.FirstSeen.UTC().Format(time.DateTime), item.GUID)
 				continue
 			}
-			output.Document = doc
+			logs.Verbosef("[%s] Item was seen before but not delivered (status=%s). Retrying.", output.Id, seen.Status)
 		}
 
 		newItems = append(newItems, output)
@@ -200,26 +398,8 @@ func compareFeedItems(oldFeed *gofeed.Feed, newFeed *gofeed.Feed, source Source)
 	return newItems
 }
 
-//go:embed document.html
-var documentTmplStr string
-var documentTmpl = createTemplate("document-template", documentTmplStr)
-
-func createTemplate(name string, t string) *template.Template {
-	return template.Must(template.New(name).Parse(t))
-}
-
-func buildDocument(item *gofeed.Item) (string, error) {
-	buf := new(bytes.Buffer)
-
-	if err := documentTmpl.Execute(buf, item); err != nil {
-		return "", fmt.Errorf("executing document template: %w", err)
-	}
-
-	return buf.String(), nil
-}
-
-func readOldFeed(path string) (*gofeed.Feed, error) {
-	log.Printf("Reading old feed at %s", path)
+func readOldFeed(logs log.Logger, path string) (*gofeed.Feed, error) {
+	logs.Printf("Reading old feed at %s", path)
 	rssFile, err := os.Open(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -231,7 +411,7 @@ func readOldFeed(path string) (*gofeed.Feed, error) {
 	defer rssFile.Close()
 
 	fp := gofeed.NewParser()
-	log.Printf("Parsing old feed at %s", rssFile.Name())
+	logs.Printf("Parsing old feed at %s", rssFile.Name())
 	feed, err := fp.Parse(rssFile)
 	if err != nil {
 		return nil, fmt.Errorf("parsing rss file: %w", err)
@@ -239,39 +419,29 @@ func readOldFeed(path string) (*gofeed.Feed, error) {
 	return feed, nil
 }
 
-func readNewFeed(url string, tmpFile *os.File) (*gofeed.Feed, error) {
-	log.Printf("Downloading new feed from %s", url)
-	if err := downloadFile(url, tmpFile); err != nil {
-		return nil, fmt.Errorf("downloading rss file: %w", err)
+func readNewFeed(ctx context.Context, logs *log.Buffer, source Source, cache httpCache, tmpFile *os.File) (*gofeed.Feed, httpCache, error) {
+	logs.Printf("Downloading new feed from %s", source.Url)
+	newCache, err := downloadFile(ctx, logs, source, cache, tmpFile)
+	if err != nil {
+		return nil, cache, fmt.Errorf("downloading rss file: %w", err)
+	}
+	if newCache == nil {
+		// not modified
+		return nil, cache, nil
 	}
 
 	// Reset file to head
-	_, err := tmpFile.Seek(0, 0)
+	_, err = tmpFile.Seek(0, 0)
 	if err != nil {
-		return nil, fmt.Errorf("reseting tmp file: %w", err)
+		return nil, cache, fmt.Errorf("reseting tmp file: %w", err)
 	}
 
 	// Parse the downloaded file
 	fp := gofeed.NewParser()
-	log.Printf("Parsing new downloaded feed")
+	logs.Printf("Parsing new downloaded feed")
 	feed, err := fp.Parse(tmpFile)
 	if err != nil {
-		return nil, fmt.Errorf("parsing rss file: %w", err)
-	}
-	return feed, nil
-}
-
-func downloadFile(url string, file *os.File) error {
-	res, err := http.Get(url)
-	if err != nil {
-		return err
+		return nil, cache, fmt.Errorf("parsing rss file: %w", err)
 	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad download status: %s", res.Status)
-	}
-
-	_, err = io.Copy(file, res.Body)
-	return err
+	return feed, *newCache, nil
 }
@@ -0,0 +1,151 @@
+//
+// filter.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package feed
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// Fields of feed items which rules can match
+var ruleFields = []string{"title", "description", "content", "author", "categories", "link"}
+
+// Rule matches feed items by a keyword or regular expression on their fields.
+type Rule struct {
+	// Fields to match. Empty means all fields.
+	Fields []string `toml:"fields,omitempty"`
+	// Case-insensitive keyword
+	Keyword string `toml:"keyword,omitempty"`
+	// Regular expression. Use (?i) for case-insensitive.
+	Regex string `toml:"regex,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// compile validates the rule and compiles its regular expression.
+func (r *Rule) compile() error {
+	if (r.Keyword == "") == (r.Regex == "") {
+		return fmt.Errorf("either keyword or regex is required")
+	}
+	for _, field := range r.Fields {
+		if !slices.Contains(ruleFields, field) {
+			return fmt.Errorf("unknown field %s. Fields are %s", field, strings.Join(ruleFields, ", "))
+		}
+	}
+	if r.Regex != "" {
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+		r.re = re
+	}
+	return nil
+}
+
+func (r *Rule) String() string {
+	fields := "any field"
+	if len(r.Fields) > 0 {
+		fields = strings.Join(r.Fields, "/")
+	}
+	if r.Regex != "" {
+		return fmt.Sprintf("%s matches regex %q", fields, r.Regex)
+	}
+	return fmt.Sprintf("%s contains keyword %q", fields, r.Keyword)
+}
+
+// match checks whether any field value of the item matches the rule. The rule must be compiled.
+func (r *Rule) match(values map[string][]string) bool {
+	if r.Regex != "" && r.re == nil {
+		// An empty keyword would match every item
+		panic(fmt.Sprintf("rule is not compiled: %s", r))
+	}
+	fields := r.Fields
+	if len(fields) == 0 {
+		fields = ruleFields
+	}
+	for _, field := range fields {
+		for _, value := range values[field] {
+			if r.Regex != "" {
+				if r.re.MatchString(value) {
+					return true
+				}
+			} else if strings.Contains(strings.ToLower(value), strings.ToLower(r.Keyword)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fieldValues returns the values of the item fields that rules can match. HTML is matched by its text.
+func fieldValues(item *gofeed.Item) map[string][]string {
+	values := map[string][]string{
+		"title":       {item.Title},
+		"description": {textOf(item.Description)},
+		"content":     {textOf(item.Content)},
+		"categories":  item.Categories,
+		"link":        {item.Link},
+	}
+	authors := item.Authors
+	if item.Author != nil {
+		authors = append([]*gofeed.Person{item.Author}, authors...)
+	}
+	for _, author := range authors {
+		if author != nil {
+			values["author"] = append(values["author"], author.Name, author.Email)
+		}
+	}
+	return values
+}
+
+func textOf(html string) string {
+	if html == "" {
+		return ""
+	}
+	text, err := stripTags(html)
+	if err != nil {
+		return html
+	}
+	return text
+}
+
+// filterItem returns the reason why the item is dropped by the include and exclude rules of the source.
+// It returns empty string if the item is kept.
+func filterItem(item *gofeed.Item, source Source) string {
+	if len(source.Include) == 0 && len(source.Exclude) == 0 {
+		return ""
+	}
+	values := fieldValues(item)
+	for i := range source.Exclude {
+		if rule := &source.Exclude[i]; rule.match(values) {
+			return fmt.Sprintf("excluded because %s", rule)
+		}
+	}
+	if len(source.Include) == 0 {
+		return ""
+	}
+	for i := range source.Include {
+		if source.Include[i].match(values) {
+			return ""
+		}
+	}
+	return "not matched by any include rule"
+}
+
+func compileRules(name string, rules []Rule) error {
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return fmt.Errorf("%s[%d]: %w", name, i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+//
+// filter_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package feed
+
+import (
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+var filterTestItem = &gofeed.Item{
+	Title:       "Release of Go 1.23",
+	Description: "<p>The <b>iterators</b> are here</p>",
+	Content:     "<div>Range over functions</div>",
+	Author:      &gofeed.Person{Name: "Gopher", Email: "gopher@example.com"},
+	Authors:     []*gofeed.Person{{Name: "Second Author"}},
+	Categories:  []string{"golang", "release"},
+	Link:        "https://example.com/go1.23",
+}
+
+func TestRuleMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		want bool
+	}{
+		{"keyword in any field", Rule{Keyword: "iterators"}, true},
+		{"keyword is case-insensitive", Rule{Keyword: "RELEASE OF"}, true},
+		{"keyword not found", Rule{Keyword: "rust"}, false},
+		{"keyword matches text of html", Rule{Keyword: "the iterators are"}, true},
+		{"keyword does not match tags", Rule{Keyword: "<b>"}, false},
+		{"regex", Rule{Regex: `Go 1\.\d+`}, true},
+		{"regex is case-sensitive", Rule{Regex: `go 1\.\d+$`}, false},
+		{"regex with (?i)", Rule{Regex: `(?i)^release of go`}, true},
+		{"regex not found", Rule{Regex: `^Rust`}, false},
+
+		{"title", Rule{Fields: []string{"title"}, Keyword: "release"}, true},
+		{"title only", Rule{Fields: []string{"title"}, Keyword: "iterators"}, false},
+		{"description", Rule{Fields: []string{"description"}, Keyword: "iterators"}, true},
+		{"content", Rule{Fields: []string{"content"}, Keyword: "range over"}, true},
+		{"author name", Rule{Fields: []string{"author"}, Keyword: "gopher"}, true},
+		{"author email", Rule{Fields: []string{"author"}, Regex: `@example\.com$`}, true},
+		{"other authors", Rule{Fields: []string{"author"}, Keyword: "second"}, true},
+		{"categories", Rule{Fields: []string{"categories"}, Regex: `^golang$`}, true},
+		{"link", Rule{Fields: []string{"link"}, Keyword: "example.com"}, true},
+		{"multiple fields", Rule{Fields: []string{"link", "categories"}, Keyword: "release"}, true},
+		{"none of fields", Rule{Fields: []string{"title", "link"}, Keyword: "gopher"}, false},
+	}
+	values := fieldValues(filterTestItem)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.compile(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.rule.match(values); got != tt.want {
+				t.Errorf("%s = %v, want %v", &tt.rule, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleCompile(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"empty", Rule{}},
+		{"keyword and regex", Rule{Keyword: "a", Regex: "a"}},
+		{"invalid regex", Rule{Regex: "("}},
+		{"unknown field", Rule{Fields: []string{"summary"}, Keyword: "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.compile(); err == nil {
+				t.Errorf("compile succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestRuleMatchNotCompiled(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("uncompiled regex rule did not panic")
+		}
+	}()
+	rule := Rule{Regex: "^Rust"}
+	rule.match(fieldValues(filterTestItem))
+}
+
+func TestFilterSourceRules(t *testing.T) {
+	config := Config{
+		Include: []Rule{{Keyword: "golang"}},
+		Exclude: []Rule{{Fields: []string{"title"}, Keyword: "beta"}},
+		Sources: map[string]Source{
+			"global": {},
+			// Include rules replace the global ones
+			"include": {Include: []Rule{{Fields: []string{"categories"}, Regex: "^rust$"}}},
+			// Exclude rules are added to the global ones
+			"exclude": {Exclude: []Rule{{Fields: []string{"link"}, Keyword: "/draft/"}}},
+		},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	sources := make(map[string]Source)
+	for _, src := range config.sortedSources() {
+		sources[src.Id] = src
+	}
+
+	item := func(title string, link string, categories ...string) *gofeed.Item {
+		return &gofeed.Item{Title: title, Link: link, Categories: categories}
+	}
+	tests := []struct {
+		source string
+		item   *gofeed.Item
+		kept   bool
+	}{
+		{"global", item("Go", "https://example.com/a", "golang"), true},
+		{"global", item("Rust", "https://example.com/a", "rust"), false},
+		{"global", item("Go beta", "https://example.com/a", "golang"), false},
+		{"global", item("Go", "https://example.com/draft/a", "golang"), true},
+
+		{"include", item("Go", "https://example.com/a", "golang"), false},
+		{"include", item("Rust", "https://example.com/a", "rust"), true},
+		{"include", item("Rust beta", "https://example.com/a", "rust"), false},
+
+		{"exclude", item("Go", "https://example.com/a", "golang"), true},
+		{"exclude", item("Rust", "https://example.com/a", "rust"), false},
+		{"exclude", item("Go beta", "https://example.com/a", "golang"), false},
+		{"exclude", item("Go", "https://example.com/draft/a", "golang"), false},
+	}
+	for _, tt := range tests {
+		reason := filterItem(tt.item, sources[tt.source])
+		if (reason == "") != tt.kept {
+			t.Errorf("%s: %q %s: kept = %v (%s), want %v", tt.source, tt.item.Title, tt.item.Link, reason == "", reason, tt.kept)
+		}
+	}
+}
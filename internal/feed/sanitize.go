@@ -0,0 +1,206 @@
+//
+// sanitize.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package feed
+
+import (
+	"html/template"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// SanitizerConfig is the allow-list of HTML in feed content. Empty list means the default.
+type SanitizerConfig struct {
+	AllowedTags []string `toml:"allowed_tags,omitempty"`
+	// Allowed attributes of each tag. Attributes of "*" are allowed in all tags.
+	AllowedAttributes map[string][]string `toml:"allowed_attributes,omitempty"`
+	// Allowed schemes of urls in href, src and similar attributes. Relative urls are always allowed.
+	AllowedSchemes []string `toml:"allowed_schemes,omitempty"`
+}
+
+var defaultAllowedTags = []string{
+	"a", "abbr", "article", "audio", "b", "blockquote", "br", "caption", "cite", "code", "col", "colgroup",
+	"dd", "del", "details", "dfn", "div", "dl", "dt", "em", "figcaption", "figure", "h1", "h2", "h3", "h4",
+	"h5", "h6", "hr", "i", "img", "ins", "kbd", "li", "mark", "ol", "p", "picture", "pre", "q", "s", "section",
+	"small", "source", "span", "strong", "sub", "summary", "sup", "table", "tbody", "td", "tfoot", "th",
+	"thead", "time", "tr", "u", "ul", "video",
+}
+
+var defaultAllowedAttributes = map[string][]string{
+	"*":          {"title", "lang", "dir"},
+	"a":          {"href"},
+	"img":        {"src", "srcset", "alt", "width", "height"},
+	"source":     {"src", "srcset", "type", "media"},
+	"video":      {"src", "poster", "controls", "width", "height"},
+	"audio":      {"src", "controls"},
+	"td":         {"colspan", "rowspan"},
+	"th":         {"colspan", "rowspan"},
+	"blockquote": {"cite"},
+	"q":          {"cite"},
+	"time":       {"datetime"},
+	"ol":         {"start"},
+}
+
+var defaultAllowedSchemes = []string{"http", "https", "mailto"}
+
+// Tags removed with their content even if they are allowed
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "iframe": true, "object": true,
+	"embed": true, "applet": true, "frame": true, "frameset": true, "svg": true, "math": true,
+	"head": true, "title": true, "meta": true, "link": true, "base": true,
+	"form": true, "input": true, "button": true, "select": true, "textarea": true,
+}
+
+// Attributes of which value is a url
+var urlAttributes = map[string]bool{
+	"href": true, "src": true, "cite": true, "poster": true, "srcset": true,
+}
+
+// sanitizer removes HTML not in the allow-list.
+type sanitizer struct {
+	tags    map[string]bool
+	attrs   map[string]map[string]bool
+	schemes map[string]bool
+}
+
+func newSanitizer(conf SanitizerConfig) *sanitizer {
+	s := &sanitizer{
+		tags:    make(map[string]bool),
+		attrs:   make(map[string]map[string]bool),
+		schemes: make(map[string]bool),
+	}
+	for _, tag := range cmpOrSlice(conf.AllowedTags, defaultAllowedTags) {
+		s.tags[strings.ToLower(tag)] = true
+	}
+	allowedAttrs := conf.AllowedAttributes
+	if len(allowedAttrs) == 0 {
+		allowedAttrs = defaultAllowedAttributes
+	}
+	for tag, attrs := range allowedAttrs {
+		s.attrs[strings.ToLower(tag)] = make(map[string]bool)
+		for _, attr := range attrs {
+			s.attrs[strings.ToLower(tag)][strings.ToLower(attr)] = true
+		}
+	}
+	for _, scheme := range cmpOrSlice(conf.AllowedSchemes, defaultAllowedSchemes) {
+		s.schemes[strings.ToLower(scheme)] = true
+	}
+	return s
+}
+
+func cmpOrSlice(values []string, defaults []string) []string {
+	if len(values) == 0 {
+		return defaults
+	}
+	return values
+}
+
+// Sanitize returns the HTML with only allowed tags, attributes and url schemes.
+// Disallowed tags are unwrapped, except dangerous ones e.g. <script> which are removed with their content.
+func (s *sanitizer) Sanitize(content string) template.HTML {
+	container := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(content), container)
+	if err != nil {
+		// Fall back to plain text
+		return template.HTML(template.HTMLEscapeString(content))
+	}
+	for _, n := range nodes {
+		container.AppendChild(n)
+	}
+	s.clean(container)
+
+	buf := new(strings.Builder)
+	for c := container.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(buf, c); err != nil {
+			return template.HTML(template.HTMLEscapeString(content))
+		}
+	}
+	return template.HTML(buf.String())
+}
+
+func (s *sanitizer) clean(parent *html.Node) {
+	for c := parent.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.ElementNode:
+			tag := strings.ToLower(c.Data)
+			if droppedTags[tag] {
+				parent.RemoveChild(c)
+			} else if !s.tags[tag] {
+				// Unwrap and clean its children next
+				first := c.FirstChild
+				for gc := c.FirstChild; gc != nil; {
+					gcNext := gc.NextSibling
+					c.RemoveChild(gc)
+					parent.InsertBefore(gc, c)
+					gc = gcNext
+				}
+				parent.RemoveChild(c)
+				if first != nil {
+					next = first
+				}
+			} else {
+				c.Attr = s.cleanAttrs(tag, c.Attr)
+				s.clean(c)
+			}
+		case html.CommentNode, html.DoctypeNode:
+			parent.RemoveChild(c)
+		}
+		c = next
+	}
+}
+
+func (s *sanitizer) cleanAttrs(tag string, attrs []html.Attribute) []html.Attribute {
+	cleaned := make([]html.Attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		key := strings.ToLower(attr.Key)
+		if attr.Namespace != "" || strings.HasPrefix(key, "on") {
+			continue
+		}
+		if !s.attrs[tag][key] && !s.attrs["*"][key] {
+			continue
+		}
+		if urlAttributes[key] && !s.allowedUrls(key, attr.Val) {
+			continue
+		}
+		cleaned = append(cleaned, attr)
+	}
+	return cleaned
+}
+
+// allowedUrls checks the schemes of the url, or all urls in srcset.
+func (s *sanitizer) allowedUrls(key string, value string) bool {
+	urls := []string{value}
+	if key == "srcset" {
+		urls = urls[:0]
+		for _, candidate := range strings.Split(value, ",") {
+			if fields := strings.Fields(candidate); len(fields) > 0 {
+				urls = append(urls, fields[0])
+			}
+		}
+	}
+	for _, rawUrl := range urls {
+		// Control characters and spaces are ignored by browsers e.g. "java\tscript:"
+		cleaned := strings.Map(func(r rune) rune {
+			if r <= ' ' {
+				return -1
+			}
+			return r
+		}, rawUrl)
+		u, err := url.Parse(cleaned)
+		if err != nil {
+			return false
+		}
+		if u.Scheme != "" && !s.schemes[strings.ToLower(u.Scheme)] {
+			return false
+		}
+	}
+	return true
+}
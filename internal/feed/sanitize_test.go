@@ -0,0 +1,122 @@
+//
+// sanitize_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package feed
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		// Dangerous elements are removed with their content
+		{"script", `<p>a</p><script>alert(1)</script><p>b</p>`, `<p>a</p><p>b</p>`},
+		{"script uppercase", `<SCRIPT>alert(1)</SCRIPT>ok`, `ok`},
+		{"style", `<style>body{display:none}</style>ok`, `ok`},
+		{"iframe", `<iframe src="https://evil.example/"></iframe>ok`, `ok`},
+		{"svg onload", `<svg onload="alert(1)"><circle r="1"></circle></svg>ok`, `ok`},
+		{"svg script", `<svg><script>alert(1)</script></svg>ok`, `ok`},
+		{"math", `<math><mtext><img src="x" onerror="alert(1)"></mtext></math>ok`, `ok`},
+		// The attribute ends noscript as browsers do. The img is then cleaned as usual.
+		{"noscript mxss", `<noscript><p title="</noscript><img src=x onerror=alert(1)>"></noscript>ok`, `<img src="x"/>&#34;&gt;ok`},
+		{"form", `<form action="https://evil.example/"><input name="password"></form>ok`, `ok`},
+		{"comment", `<!-- <script>alert(1)</script> -->ok`, `ok`},
+
+		// Event handlers and disallowed attributes are removed
+		{"img onerror", `<img src="https://example.com/a.png" onerror="alert(1)">`, `<img src="https://example.com/a.png"/>`},
+		{"onclick", `<p onclick="alert(1)" OnMouseOver="alert(2)">a</p>`, `<p>a</p>`},
+		{"style attribute", `<p style="background:url(javascript:alert(1))" class="x" id="y">a</p>`, `<p>a</p>`},
+		{"allowed attributes", `<a href="https://example.com/" title="t" target="_blank">a</a>`, `<a href="https://example.com/" title="t">a</a>`},
+
+		// Urls with disallowed schemes are removed
+		{"javascript url", `<a href="javascript:alert(1)">a</a>`, `<a>a</a>`},
+		{"javascript url mixed case", `<a href="JaVaScRiPt:alert(1)">a</a>`, `<a>a</a>`},
+		{"javascript url with tab", "<a href=\"java\tscript:alert(1)\">a</a>", `<a>a</a>`},
+		{"javascript url with tab entity", `<a href="java&#9;script:alert(1)">a</a>`, `<a>a</a>`},
+		{"javascript url with newline entity", `<a href="java&#x0A;script:alert(1)">a</a>`, `<a>a</a>`},
+		{"javascript url with leading space", `<a href=" &#32;javascript:alert(1)">a</a>`, `<a>a</a>`},
+		{"javascript url decimal entities", `<a href="&#106;&#97;&#118;&#97;&#115;&#99;&#114;&#105;&#112;&#116;&#58;alert(1)">a</a>`, `<a>a</a>`},
+		{"javascript url hex entities", `<a href="&#x6A;avascript&#x3A;alert(1)">a</a>`, `<a>a</a>`},
+		{"javascript url named entity", `<a href="javascript&colon;alert(1)">a</a>`, `<a>a</a>`},
+		{"vbscript url", `<a href="vbscript:msgbox(1)">a</a>`, `<a>a</a>`},
+		{"data url", `<img src="data:image/svg+xml;base64,PHN2Zz4=">`, `<img/>`},
+		{"video poster", `<video poster="javascript:alert(1)" controls></video>`, `<video controls=""></video>`},
+		{"blockquote cite", `<blockquote cite="javascript:alert(1)">q</blockquote>`, `<blockquote>q</blockquote>`},
+		{"allowed urls", `<a href="mailto:me@example.com">m</a><a href="/relative?a=1&amp;b=2">r</a>`, `<a href="mailto:me@example.com">m</a><a href="/relative?a=1&amp;b=2">r</a>`},
+
+		// srcset is removed if any candidate is not allowed
+		{"srcset", `<img src="a.png" srcset="a.png 1x, https://example.com/b.png 2x">`, `<img src="a.png" srcset="a.png 1x, https://example.com/b.png 2x"/>`},
+		{"srcset bad candidate", `<img src="a.png" srcset="a.png 1x, javascript:alert(1) 2x">`, `<img src="a.png"/>`},
+
+		// Disallowed tags are unwrapped
+		{"unwrap", `<div><font color="red">a <b>b</b></font> c</div>`, `<div>a <b>b</b> c</div>`},
+		{"unwrap nested", `<center><marquee><p onclick="x">a</p></marquee></center>`, `<p>a</p>`},
+		{"unwrap keeps dropping", `<font><script>alert(1)</script>a</font>`, `a`},
+
+		// Text is escaped
+		{"text", `a < b & "c"`, `a &lt; b &amp; &#34;c&#34;`},
+		{"attribute quote", `<img src="a.png" alt="&quot; onerror=&quot;alert(1)">`, `<img src="a.png" alt="&#34; onerror=&#34;alert(1)"/>`},
+	}
+	s := newSanitizer(SanitizerConfig{})
+	for _, tt := range tests {
+		if got := string(s.Sanitize(tt.in)); got != tt.want {
+			t.Errorf("%s:\n  input: %s\n    got: %s\n   want: %s", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeCustomConfig(t *testing.T) {
+	s := newSanitizer(SanitizerConfig{
+		AllowedTags:       []string{"p", "a", "script", "iframe", "SPAN"},
+		AllowedAttributes: map[string][]string{"a": {"href", "onclick"}, "*": {"class"}},
+		AllowedSchemes:    []string{"https"},
+	})
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"script is still dropped", `<script>alert(1)</script><p>a</p>`, `<p>a</p>`},
+		{"iframe is still dropped", `<iframe src="https://example.com/"></iframe><p>a</p>`, `<p>a</p>`},
+		{"event handler is still removed", `<a href="https://example.com/" onclick="alert(1)">a</a>`, `<a href="https://example.com/">a</a>`},
+		{"custom tags and attributes", `<span class="x"><b>a</b></span>`, `<span class="x">a</span>`},
+		{"custom schemes", `<a href="http://example.com/">a</a><a href="https://example.com/">b</a>`, `<a>a</a><a href="https://example.com/">b</a>`},
+		{"javascript is not allowed", `<a href="javascript:alert(1)">a</a>`, `<a>a</a>`},
+	}
+	for _, tt := range tests {
+		if got := string(s.Sanitize(tt.in)); got != tt.want {
+			t.Errorf("%s:\n  input: %s\n    got: %s\n   want: %s", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeNoScriptOutput(t *testing.T) {
+	// Whatever the input, no executable markup survives
+	inputs := []string{
+		`<scr<script>ipt>alert(1)</scr</script>ipt>`,
+		`<<script>script>alert(1)<</script>/script>`,
+		`<img src=x onerror=alert(1)//`,
+		`<a href="jav&#x09;ascript:alert(1)">a</a>`,
+		`<svg><a xlink:href="javascript:alert(1)"><text>a</text></a></svg>`,
+		`<table><td><script>alert(1)</script></td></table>`,
+		`<select><template><img src=x onerror=alert(1)></template></select>`,
+	}
+	s := newSanitizer(SanitizerConfig{})
+	for _, in := range inputs {
+		got := strings.ToLower(string(s.Sanitize(in)))
+		for _, bad := range []string{"<script", "onerror=", "javascript:", "<svg", "<template"} {
+			if strings.Contains(got, bad) {
+				t.Errorf("Sanitize(%q) = %q contains %q", in, got, bad)
+			}
+		}
+	}
+}
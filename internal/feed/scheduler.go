@@ -0,0 +1,67 @@
+//
+// scheduler.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package feed
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+)
+
+// Poll finds new items from each source repeatedly at its own interval until the context is done.
+// Each source is polled by its own loop so a slow source or delivery does not delay the other sources.
+// At most config.Concurrency feeds are downloaded at the same time.
+func Poll(ctx context.Context, config Config, dataDir string, consumer NewItemConsumer) {
+	sources := config.sortedSources()
+	if len(sources) == 0 {
+		log.Warn("No feed source to poll")
+		return
+	}
+	log.Infof("Polling %d feed sources", len(sources))
+
+	downloads := make(chan struct{}, max(1, config.Concurrency))
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pollSource(ctx, src, dataDir, consumer, downloads)
+		}()
+	}
+	wg.Wait()
+}
+
+// pollSource finds new items from the source at its interval until the context is done.
+// The source is due at start. Its next run is scheduled after the current run finishes.
+func pollSource(ctx context.Context, src Source, dataDir string, consumer NewItemConsumer, downloads chan struct{}) {
+	for {
+		// Wait for a download slot
+		select {
+		case <-ctx.Done():
+			return
+		case downloads <- struct{}{}:
+		}
+		f := fetchFeed(ctx, src, sourceDir(dataDir, src))
+		<-downloads
+
+		// Logs of the source are written in one piece so they are not mixed with other sources
+		var logs log.Buffer
+		processSource(ctx, &logs, src, dataDir, f, consumer)
+		logs.Flush()
+
+		next := time.Now().Add(src.Interval)
+		log.Verbosef("Next poll of rss source (%s) at %s", src.Id, next.Format(time.DateTime))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
@@ -0,0 +1,163 @@
+//
+// seen.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package feed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+)
+
+type SeenStatus string
+
+const (
+	StatusDelivered SeenStatus = "delivered"
+	StatusFailed    SeenStatus = "failed"
+)
+
+// SeenItem is an item that has been found in a feed source before.
+type SeenItem struct {
+	Guid      string     `json:"guid,omitempty"`
+	Link      string     `json:"link"`
+	FirstSeen time.Time  `json:"first_seen"`
+	Status    SeenStatus `json:"status"`
+}
+
+// seenDB is a per-source store of every item ever found in the feed source.
+// It is persisted as a json file in the source data directory.
+type seenDB struct {
+	path  string
+	Items []*SeenItem `json:"items"`
+	guids map[string]*SeenItem
+	links map[string]*SeenItem
+}
+
+func newSeenDB(path string) *seenDB {
+	return &seenDB{
+		path:  path,
+		Items: make([]*SeenItem, 0),
+		guids: make(map[string]*SeenItem),
+		links: make(map[string]*SeenItem),
+	}
+}
+
+// openSeenDB reads the seen-item database in the directory.
+// If it does not exist yet, it is seeded from the old feed file of previous runs.
+func openSeenDB(logs log.Logger, dir string, oldFeedPath string) (*seenDB, error) {
+	db := newSeenDB(filepath.Join(dir, "seen.json"))
+
+	logs.Printf("Reading seen-item database at %s", db.path)
+	data, err := os.ReadFile(db.path)
+	if err == nil {
+		if err := json.Unmarshal(data, db); err != nil {
+			return nil, fmt.Errorf("parsing seen-item database: %w", err)
+		}
+		for _, item := range db.Items {
+			db.index(item)
+		}
+		return db, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// Migrate from old feed file
+	if err := db.seed(logs, oldFeedPath); err != nil {
+		return nil, fmt.Errorf("seeding seen-item database: %w", err)
+	}
+	return db, nil
+}
+
+func (db *seenDB) seed(logs log.Logger, oldFeedPath string) error {
+	oldFeed, err := readOldFeed(logs, oldFeedPath)
+	if err != nil {
+		return fmt.Errorf("reading old rss file: %w", err)
+	}
+	if oldFeed == nil {
+		return nil
+	}
+	fi, err := os.Stat(oldFeedPath)
+	if err != nil {
+		return err
+	}
+
+	logs.Printf("Seeding seen-item database with %d items from old feed", len(oldFeed.Items))
+	for _, item := range oldFeed.Items {
+		if item.GUID == "" && item.Link == "" {
+			continue
+		}
+		db.record(item.GUID, item.Link, StatusDelivered, fi.ModTime())
+	}
+	return db.save()
+}
+
+func (db *seenDB) index(item *SeenItem) {
+	if item.Guid != "" {
+		db.guids[item.Guid] = item
+	}
+	if item.Link != "" {
+		db.links[item.Link] = item
+	}
+}
+
+// lookup finds the seen item by guid first, then by link.
+func (db *seenDB) lookup(guid string, link string) *SeenItem {
+	if guid != "" {
+		if item := db.guids[guid]; item != nil {
+			return item
+		}
+	}
+	if link != "" {
+		if item := db.links[link]; item != nil {
+			return item
+		}
+	}
+	return nil
+}
+
+// record adds the item to the database or updates its delivery status.
+func (db *seenDB) record(guid string, link string, status SeenStatus, now time.Time) {
+	item := db.lookup(guid, link)
+	if item == nil {
+		item = &SeenItem{
+			Guid:      guid,
+			Link:      link,
+			FirstSeen: now,
+		}
+		db.Items = append(db.Items, item)
+	} else if guid != "" && item.Guid != guid {
+		// Found by link but the feed changed its guid
+		if item.Guid != "" {
+			delete(db.guids, item.Guid)
+		}
+		item.Guid = guid
+	}
+	item.Status = status
+	db.index(item)
+}
+
+func (db *seenDB) save() error {
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding seen-item database: %w", err)
+	}
+
+	// Write to temp file then rename to avoid corrupted database
+	tmpPath := db.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0640); err != nil {
+		return fmt.Errorf("writing seen-item database: %w", err)
+	}
+	if err := os.Rename(tmpPath, db.path); err != nil {
+		return fmt.Errorf("saving seen-item database: %w", err)
+	}
+	return nil
+}
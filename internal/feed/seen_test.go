@@ -0,0 +1,175 @@
+//
+// seen_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package feed
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/teerapap/feed-to-pocket/internal/log"
+)
+
+func init() {
+	log.Initialize(io.Discard)
+}
+
+const oldFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"><channel><title>Old</title>
+<item><title>A</title><link>https://example.com/a</link><guid>guid-a</guid></item>
+<item><title>B</title><link>https://example.com/b</link></item>
+<item><title>No link and guid</title></item>
+</channel></rss>`
+
+func TestSeenDBMigratesOldFeed(t *testing.T) {
+	dir := t.TempDir()
+	rssPath := filepath.Join(dir, "feed.xml")
+	if err := os.WriteFile(rssPath, []byte(oldFeed), 0640); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(rssPath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openSeenDB(log.Std, dir, rssPath)
+	if err != nil {
+		t.Fatalf("opening seen-item database: %s", err)
+	}
+	if len(db.Items) != 2 {
+		t.Fatalf("got %d seeded items, want 2", len(db.Items))
+	}
+	for _, tt := range []struct{ guid, link string }{{"guid-a", "https://example.com/a"}, {"", "https://example.com/b"}} {
+		item := db.lookup(tt.guid, tt.link)
+		if item == nil {
+			t.Errorf("item %s is not seeded", tt.link)
+			continue
+		}
+		if item.Status != StatusDelivered || !item.FirstSeen.Equal(modTime) {
+			t.Errorf("item %s = %+v, want delivered and first seen at %s", tt.link, item, modTime)
+		}
+	}
+
+	// Seeded database is saved and read instead of the old feed afterwards
+	if err := os.Remove(rssPath); err != nil {
+		t.Fatal(err)
+	}
+	db, err = openSeenDB(log.Std, dir, rssPath)
+	if err != nil {
+		t.Fatalf("reopening seen-item database: %s", err)
+	}
+	if len(db.Items) != 2 || db.lookup("guid-a", "") == nil || db.lookup("", "https://example.com/b") == nil {
+		t.Errorf("reopened items = %+v, want the seeded items", db.Items)
+	}
+}
+
+func TestSeenDBWithoutOldFeed(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openSeenDB(log.Std, dir, filepath.Join(dir, "feed.xml"))
+	if err != nil {
+		t.Fatalf("opening seen-item database: %s", err)
+	}
+	if len(db.Items) != 0 {
+		t.Errorf("got %d items, want empty database", len(db.Items))
+	}
+}
+
+func TestSeenDBLookup(t *testing.T) {
+	dir := t.TempDir()
+	db := newSeenDB(filepath.Join(dir, "seen.json"))
+	now := time.Now()
+	db.record("guid-a", "https://example.com/a", StatusDelivered, now)
+	db.record("", "https://example.com/b", StatusDelivered, now)
+
+	tests := []struct {
+		name  string
+		guid  string
+		link  string
+		found string
+	}{
+		{"guid", "guid-a", "", "https://example.com/a"},
+		{"guid with other link", "guid-a", "https://example.com/moved", "https://example.com/a"},
+		{"link", "", "https://example.com/b", "https://example.com/b"},
+		{"link with unknown guid", "guid-new", "https://example.com/b", "https://example.com/b"},
+		{"unknown", "guid-c", "https://example.com/c", ""},
+	}
+	for _, tt := range tests {
+		item := db.lookup(tt.guid, tt.link)
+		if tt.found == "" {
+			if item != nil {
+				t.Errorf("%s: found %+v, want nothing", tt.name, item)
+			}
+		} else if item == nil || item.Link != tt.found {
+			t.Errorf("%s: found %+v, want %s", tt.name, item, tt.found)
+		}
+	}
+
+	// Guid of an item found by link is updated
+	db.record("guid-b", "https://example.com/b", StatusDelivered, now)
+	db.record("guid-a2", "https://example.com/a", StatusDelivered, now)
+	if err := db.save(); err != nil {
+		t.Fatalf("saving seen-item database: %s", err)
+	}
+	db, err := openSeenDB(log.Std, dir, filepath.Join(dir, "feed.xml"))
+	if err != nil {
+		t.Fatalf("reopening seen-item database: %s", err)
+	}
+	if len(db.Items) != 2 {
+		t.Errorf("got %d items, want 2", len(db.Items))
+	}
+	if item := db.lookup("guid-b", ""); item == nil || item.Link != "https://example.com/b" {
+		t.Errorf("lookup by new guid-b = %+v, want https://example.com/b", item)
+	}
+	if item := db.lookup("guid-a2", ""); item == nil || item.Link != "https://example.com/a" {
+		t.Errorf("lookup by new guid-a2 = %+v, want https://example.com/a", item)
+	}
+	if item := db.lookup("guid-a", ""); item != nil {
+		t.Errorf("lookup by stale guid-a = %+v, want nothing", item)
+	}
+}
+
+func TestFailedItemsAreRetried(t *testing.T) {
+	db := newSeenDB(filepath.Join(t.TempDir(), "seen.json"))
+	source := Source{Id: "test"}
+	newFeed := &gofeed.Feed{Items: []*gofeed.Item{
+		{GUID: "a", Link: "https://example.com/a"},
+		{GUID: "b", Link: "https://example.com/b"},
+		{GUID: "c", Link: "https://example.com/c"},
+	}}
+
+	items := compareFeedItems(log.Std, db, newFeed, source)
+	if len(items) != 3 {
+		t.Fatalf("got %d new items, want 3", len(items))
+	}
+	failed := recordItems(db, items, []error{nil, errors.New("failed"), nil}, nil)
+	if failed != 1 {
+		t.Errorf("recordItems = %d failed, want 1", failed)
+	}
+	if item := db.lookup("b", ""); item == nil || item.Status != StatusFailed {
+		t.Errorf("failed item = %+v, want status failed", item)
+	}
+
+	items = compareFeedItems(log.Std, db, newFeed, source)
+	if len(items) != 1 || items[0].Guid != "b" {
+		t.Fatalf("new items = %+v, want only the failed item b", items)
+	}
+
+	// All items fail with a common error
+	recordItems(db, items, nil, errors.New("failed"))
+	if items = compareFeedItems(log.Std, db, newFeed, source); len(items) != 1 {
+		t.Errorf("got %d new items, want the failed item again", len(items))
+	}
+	recordItems(db, items, []error{nil}, nil)
+	if items = compareFeedItems(log.Std, db, newFeed, source); len(items) != 0 {
+		t.Errorf("got %d new items, want none after delivery", len(items))
+	}
+}
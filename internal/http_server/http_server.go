@@ -8,117 +8,388 @@
 package http_server
 
 import (
+	"cmp"
 	"context"
-	"crypto/md5"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/teerapap/feed-to-pocket/internal/log"
 	"github.com/teerapap/feed-to-pocket/internal/util"
 )
 
 type Config struct {
+	// TCP address or "unix:PATH" of unix socket
 	ListenAddr string  `toml:"listen"`
 	BaseUrl    string  `toml:"base_url"`
 	baseUrl    url.URL // parsed BaseUrl
-	RandomUrl  bool    `toml:"random_url,omitempty"`
+	// Secret to sign content urls. It can be loaded with "env:NAME" or "file:PATH".
+	// A random secret is generated in data directory if it is empty.
+	Secret string `toml:"secret,omitempty"`
+	// Longest time to wait for a served content to be fetched
+	FetchTimeout time.Duration `toml:"fetch_timeout,omitempty"`
+	// Add the item again if its content is not fetched in time
+	FetchRetry bool `toml:"fetch_retry,omitempty"`
+	// How long served contents are kept in data directory
+	Retention time.Duration `toml:"retention,omitempty"`
+	// Serve HTTPS with the certificate. It is reloaded when the files are changed.
+	TlsCert string `toml:"tls_cert,omitempty"`
+	TlsKey  string `toml:"tls_key,omitempty"`
+	// Download images in documents and serve them from the server
+	ProxyImages bool `toml:"proxy_images,omitempty"`
+	// Token to access /status as bearer token or basic auth password. /status is not served if it is empty.
+	// It can be loaded with "env:NAME" or "file:PATH".
+	StatusToken string `toml:"status_token,omitempty"`
 }
 
+const DefaultFetchTimeout = 5 * time.Minute
+
+// Interval to remove expired stored contents, media and statistics while the server runs
+const cleanupInterval = 1 * time.Hour
+
+// Server serves contents for Pocket to fetch. It is safe for concurrent use.
 type Server struct {
 	Config   Config
 	Srv      http.Server
+	mux      *http.ServeMux
 	stopped  chan error
-	Contents map[string]*Content
+	mu       sync.RWMutex
+	contents map[string]*Content // guarded by mu
+	store    *Store
+	secret   []byte
+	stopping atomic.Bool
+	// Closed to stop the cleanup loop
+	stopCleanup chan struct{}
+	// Token of /status. Empty if /status is not served.
+	statusToken string
+
+	mediaDir    string
+	mediaClient *http.Client
 }
 
 type Content struct {
 	Id       string
-	Document string
+	Document string // guarded by Server.mu. Empty if released from memory.
 	FullUrl  string
 	Done     chan error
+	key      string
+
+	// Fetch statistics guarded by Server.mu
+	servedAt     time.Time
+	fetches      int
+	firstFetched time.Time
+	lastFetched  time.Time
 }
 
-func NewServer(conf Config) (*Server, error) {
+// NewServer starts the content server. Served contents are also stored in the content directory of data directory.
+func NewServer(conf Config, dataDir string) (*Server, error) {
 	if err := conf.baseUrl.UnmarshalBinary([]byte(conf.BaseUrl)); err != nil {
 		return nil, fmt.Errorf("http_server.base_url is not valid: %w", err)
 	}
+	store, err := NewStore(filepath.Join(dataDir, "content"), conf.Retention)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := loadSecret(conf.Secret, filepath.Join(dataDir, "content_secret"))
+	if err != nil {
+		return nil, err
+	}
+	statusToken, err := util.ResolveValue(conf.StatusToken)
+	if err != nil {
+		return nil, fmt.Errorf("http_server.status_token: %w", err)
+	}
+	mediaDir := filepath.Join(dataDir, "media")
+	var mediaClient *http.Client
+	if conf.ProxyImages {
+		if err := os.MkdirAll(mediaDir, 0750); err != nil {
+			return nil, fmt.Errorf("creating media directory: %w", err)
+		}
+		mediaClient = util.PublicClient(imageTimeout)
+	}
 
 	log.Infof("Starting content HTTP server on %s", conf.ListenAddr)
 	server := &Server{
 		Config:   conf,
-		Contents: make(map[string]*Content, 0),
-		stopped:  make(chan error),
+		mux:      http.NewServeMux(),
+		contents: make(map[string]*Content, 0),
+		stopped:  make(chan error, 1),
+		store:    store,
+		secret:   secret,
+
+		statusToken: statusToken,
+
+		stopCleanup: make(chan struct{}),
+
+		mediaDir:    mediaDir,
+		mediaClient: mediaClient, // nil if images are not proxied
+	}
+	server.Srv.Handler = server.mux
+	server.Cleanup()
+
+	useTls := conf.TlsCert != "" || conf.TlsKey != ""
+	if useTls {
+		reloader, err := newCertReloader(conf.TlsCert, conf.TlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("http_server.tls_cert or tls_key is not valid: %w", err)
+		}
+		server.Srv.TLSConfig = &tls.Config{
+			GetCertificate: reloader.getCertificate,
+		}
 	}
 
 	// Try to bind address
-	l, err := net.Listen("tcp", conf.ListenAddr)
+	l, err := listen(conf.ListenAddr)
 	if err != nil {
 		return nil, fmt.Errorf("listening to socket: %w", err)
 	}
 
 	// Handlers
-	http.HandleFunc("GET /content/", func(w http.ResponseWriter, r *http.Request) {
-		log.Verbosef("Received GET content request: %s", r.URL.Path)
-
-		// get key querystring value
-		hashId, htmlExt := strings.CutSuffix(path.Base(r.URL.Path), ".html")
-		if !htmlExt {
-			http.NotFound(w, r)
-			return
-		}
-		content := server.Contents[hashId]
-		if content == nil {
-			http.NotFound(w, r)
-			return
-		}
-
-		fmt.Fprint(w, content.Document)
-		log.Infof("Content is served: %s", content.Id)
-		select {
-		case content.Done <- nil:
-		default:
-		}
-	})
+	server.mux.HandleFunc("GET /content/", server.handleContent)
+	server.mux.HandleFunc("GET /media/", server.handleMedia)
+	server.mux.HandleFunc("GET /healthz", server.handleHealthz)
+	server.mux.HandleFunc("GET /readyz", server.handleReadyz)
+	if statusToken != "" {
+		// It lists served contents so it is not public
+		server.mux.HandleFunc("GET /status", server.handleStatus)
+	}
 
 	go func() {
-		err := server.Srv.Serve(l)
+		var err error
+		if useTls {
+			err = server.Srv.ServeTLS(l, "", "")
+		} else {
+			err = server.Srv.Serve(l)
+		}
 		if err != http.ErrServerClosed {
 			log.Errorf("listening and serve http content: %v", err)
 			server.stopped <- err
 		}
 		close(server.stopped)
 	}()
+	go server.cleanupLoop()
 	log.Infof("Started content HTTP server on %s", conf.ListenAddr)
 
 	return server, nil
 }
 
+func (hc *Server) handleContent(w http.ResponseWriter, r *http.Request) {
+	log.Verbosef("Received GET content request: %s", r.URL.Path)
+
+	key, htmlExt := strings.CutSuffix(path.Base(r.URL.Path), ".html")
+	if !htmlExt {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	if err := hc.verify(key, query.Get("exp"), query.Get("sig"), time.Now()); err != nil {
+		log.Verbosef("Rejected content request %s: %s", key, err)
+		http.NotFound(w, r)
+		return
+	}
+
+	doc := hc.document(key)
+	if doc == "" {
+		// Released or content of previous runs
+		stored, found, err := hc.store.Load(key)
+		if err != nil {
+			log.Errorf("loading stored content %s: %s", key, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		doc = stored
+	}
+
+	fmt.Fprint(w, doc)
+	content := hc.recordFetch(key, time.Now())
+	log.Infof("Content is served: %s", cmp.Or(content.Id, key))
+	select {
+	case content.Done <- nil:
+	default:
+	}
+}
+
+// listen binds the TCP address or unix socket of "unix:PATH".
+func listen(addr string) (net.Listener, error) {
+	socketPath, isUnix := strings.CutPrefix(addr, "unix:")
+	if !isUnix {
+		return net.Listen("tcp", addr)
+	}
+	// Remove stale socket of previous runs
+	if fi, err := os.Stat(socketPath); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(socketPath); err != nil {
+			return nil, fmt.Errorf("removing stale socket: %w", err)
+		}
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	// Allow local proxy in the same group to connect
+	if err := os.Chmod(socketPath, 0660); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("changing socket permission: %w", err)
+	}
+	return l, nil
+}
+
+// Handle registers an additional handler on the server.
+func (hc *Server) Handle(pattern string, handler http.HandlerFunc) {
+	hc.mux.HandleFunc(pattern, handler)
+}
+
+// document returns the document in memory. It is empty if it is not in memory.
+func (hc *Server) document(key string) string {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	if c := hc.contents[key]; c != nil {
+		return c.Document
+	}
+	return ""
+}
+
+// recordFetch records a fetch of the content. Stored contents of previous runs are added to track their fetches.
+func (hc *Server) recordFetch(key string, now time.Time) *Content {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	c := hc.contents[key]
+	if c == nil {
+		c = &Content{
+			Done: make(chan error, 1),
+			key:  key,
+		}
+		if storedAt, ok := hc.store.StoredAt(key); ok {
+			c.servedAt = storedAt
+		}
+		hc.contents[key] = c
+	}
+	if c.fetches == 0 {
+		c.firstFetched = now
+	}
+	c.fetches++
+	c.lastFetched = now
+	return c
+}
+
+// Url returns the full url of the path on the server.
+func (hc *Server) Url(elem ...string) string {
+	return hc.Config.baseUrl.JoinPath(elem...).String()
+}
+
+// ServeContent serves the document at a signed url which expires after the retention.
+// The url is the same across runs until it expires.
 func (hc *Server) ServeContent(id string, document string) *Content {
-	var hashId string
-	if hc.Config.RandomUrl {
-		hashId = fmt.Sprintf("%x", md5.Sum([]byte(id+util.RandString(8))))
-	} else {
-		hashId = fmt.Sprintf("%x", md5.Sum([]byte(id)))
+	key := hc.contentKey(id)
+	if hc.Config.ProxyImages {
+		document = hc.proxyImages(document)
 	}
-	fullUrl := hc.Config.baseUrl.JoinPath("content", hashId+".html")
+	fullUrl, storedAt := hc.contentUrl(key)
 
 	c := &Content{
 		Id:       id,
-		FullUrl:  fullUrl.String(),
+		FullUrl:  fullUrl,
 		Document: document,
 		Done:     make(chan error, 1),
+		key:      key,
+		servedAt: time.Now(),
+	}
+	hc.mu.Lock()
+	hc.contents[key] = c
+	hc.mu.Unlock()
+	if err := hc.store.Save(key, document, storedAt); err != nil {
+		log.Errorf("storing content %s: %s", id, err)
 	}
-	hc.Contents[hashId] = c
 	log.Infof("Serving content %s at %s", id, fullUrl)
 	return c
 }
 
+// ContentUrl returns the url at which the content of the id is served, or will be served if it is not stored yet.
+func (hc *Server) ContentUrl(id string) string {
+	fullUrl, _ := hc.contentUrl(hc.contentKey(id))
+	return fullUrl
+}
+
+// contentUrl returns the signed url of the content key and the time it is stored.
+// Contents stored by previous runs keep their urls.
+func (hc *Server) contentUrl(key string) (string, time.Time) {
+	storedAt := time.Now().Truncate(time.Second)
+	if t, ok := hc.store.StoredAt(key); ok {
+		storedAt = t
+	}
+	expiry := storedAt.Add(hc.store.retention)
+	fullUrl := hc.Config.baseUrl.JoinPath("content", key+".html")
+	query := url.Values{}
+	query.Set("exp", strconv.FormatInt(expiry.Unix(), 10))
+	query.Set("sig", hc.sign(key, expiry))
+	fullUrl.RawQuery = query.Encode()
+	return fullUrl.String(), storedAt
+}
+
+// Release removes the document from memory. It is still served from the store until it expires.
+func (hc *Server) Release(c *Content) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	c.Document = ""
+}
+
+// Cleanup removes expired stored contents, media and fetch statistics. It runs hourly while the server runs.
+func (hc *Server) Cleanup() {
+	removed, err := hc.store.Cleanup()
+	if err != nil {
+		log.Errorf("cleaning up stored contents: %s", err)
+	}
+	if removed > 0 {
+		log.Infof("Removed %d expired stored contents", removed)
+	}
+	removed, err = hc.cleanupMedia()
+	if err != nil {
+		log.Errorf("cleaning up media: %s", err)
+	}
+	if removed > 0 {
+		log.Infof("Removed %d expired media", removed)
+	}
+
+	expired := time.Now().Add(-hc.store.retention)
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	for key, c := range hc.contents {
+		if c.Document == "" && c.servedAt.Before(expired) {
+			delete(hc.contents, key)
+		}
+	}
+}
+
+// cleanupLoop runs Cleanup periodically until the server is shut down.
+func (hc *Server) cleanupLoop() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hc.stopCleanup:
+			return
+		case <-ticker.C:
+			hc.Cleanup()
+		}
+	}
+}
+
 func (hc *Server) Shutdown() error {
 	log.Info("Shutting down content HTTP server")
+	hc.stopping.Store(true)
+	close(hc.stopCleanup)
 	if err := hc.Srv.Shutdown(context.Background()); err != nil {
 		return fmt.Errorf("shutting down: %w", err)
 	}
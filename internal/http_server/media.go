@@ -0,0 +1,225 @@
+//
+// media.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package http_server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+	"github.com/teerapap/feed-to-pocket/internal/util"
+)
+
+// Largest image to download
+const maxImageSize = 20 * 1024 * 1024
+
+const imageTimeout = 30 * time.Second
+
+// Longest time to proxy all images of a document. Remaining images are kept as is.
+const proxyTimeout = 1 * time.Minute
+
+// File extensions of image types that are safe to serve from our domain. SVG may contain scripts.
+var imageExts = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/avif": ".avif",
+}
+
+// proxyImages rewrites <img src> in the document to media urls of the server.
+// Other candidates of the proxied images i.e. srcset and <source> of <picture> are removed.
+// Images are downloaded and cached in the media directory. Images which cannot be downloaded are kept as is.
+// All images are downloaded in at most proxyTimeout.
+func (hc *Server) proxyImages(document string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(document))
+	if err != nil {
+		log.Errorf("parsing document for images: %s", err)
+		return document
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), proxyTimeout)
+	defer cancel()
+	rewritten := 0
+	skipped := 0
+	doc.Find("img[src]").Each(func(_ int, img *goquery.Selection) {
+		src := img.AttrOr("src", "")
+		u, err := url.Parse(src)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		if ctx.Err() != nil {
+			skipped++
+			return
+		}
+		name, err := hc.cacheImage(ctx, u)
+		if err != nil {
+			log.Warnf("Cannot proxy image %s: %s", src, err)
+			return
+		}
+		img.SetAttr("src", hc.Url("media", name))
+		// Other candidates are not proxied. Browsers prefer <source> of <picture> over <img src>.
+		img.RemoveAttr("srcset")
+		img.ParentFiltered("picture").ChildrenFiltered("source[srcset]").Remove()
+		rewritten++
+	})
+	if skipped > 0 {
+		log.Warnf("Not proxied %d images because proxying took longer than %s", skipped, proxyTimeout)
+	}
+	if rewritten == 0 {
+		return document
+	}
+	html, err := goquery.OuterHtml(doc.Selection)
+	if err != nil {
+		log.Errorf("rendering document with proxied images: %s", err)
+		return document
+	}
+	log.Verbosef("Proxied %d images", rewritten)
+	return html
+}
+
+// cacheImage downloads the image into the media directory if it is not cached yet and returns its file name.
+func (hc *Server) cacheImage(ctx context.Context, u *url.URL) (string, error) {
+	sum := sha256.Sum256([]byte(u.String()))
+	hash := hex.EncodeToString(sum[:16])
+
+	// Already cached with any image type
+	for _, ext := range imageExts {
+		p := filepath.Join(hc.mediaDir, hash+ext)
+		if _, err := os.Stat(p); err == nil {
+			// Keep it as long as the latest document using it
+			now := time.Now()
+			if err := os.Chtimes(p, now, now); err != nil {
+				return "", err
+			}
+			return hash + ext, nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	// Hotlink protection usually accepts its own site as referer
+	req.Header.Set("Referer", u.Scheme+"://"+u.Host+"/")
+	req.Header.Set("User-Agent", "feed-to-pocket/"+util.AppVersion)
+	resp, err := hc.mediaClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("downloading image: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad download status: %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	ext, ok := imageExts[mediaType]
+	if !ok {
+		return "", fmt.Errorf("unsupported image type: %s", mediaType)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return "", fmt.Errorf("downloading image: %w", err)
+	}
+	if len(data) > maxImageSize {
+		return "", fmt.Errorf("image is larger than %d bytes", maxImageSize)
+	}
+	name := hash + ext
+	tmpPath := filepath.Join(hc.mediaDir, name+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0640); err != nil {
+		return "", fmt.Errorf("writing image file: %w", err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(hc.mediaDir, name)); err != nil {
+		return "", fmt.Errorf("saving image file: %w", err)
+	}
+	return name, nil
+}
+
+func (hc *Server) handleMedia(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	hash, ext, _ := strings.Cut(name, ".")
+	if !validKey(hash) || !isImageExt("."+ext) {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(filepath.Join(hc.mediaDir, name))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Errorf("opening media %s: %s", name, err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	log.Verbosef("Media is served: %s", name)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+}
+
+func isImageExt(ext string) bool {
+	for _, e := range imageExts {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupMedia removes images older than the retention and returns the number of removed images.
+// Media of previous runs are removed even if proxy_images is disabled now.
+func (hc *Server) cleanupMedia() (int, error) {
+	entries, err := os.ReadDir(hc.mediaDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("reading media directory: %w", err)
+	}
+	expired := time.Now().Add(-hc.store.retention)
+	removed := 0
+	for _, entry := range entries {
+		fi, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			// Left by interrupted downloads
+			if fi.ModTime().Add(tmpGracePeriod).Before(time.Now()) {
+				os.Remove(filepath.Join(hc.mediaDir, entry.Name()))
+			}
+			continue
+		}
+		if !fi.ModTime().Before(expired) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(hc.mediaDir, entry.Name())); err != nil {
+			return removed, fmt.Errorf("removing expired media: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
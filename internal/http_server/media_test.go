@@ -0,0 +1,89 @@
+//
+// media_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package http_server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/teerapap/feed-to-pocket/internal/util"
+)
+
+func TestCacheImageRejectsPrivateAddress(t *testing.T) {
+	requested := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+		w.Header().Set("Content-Type", "image/png")
+	}))
+	defer srv.Close()
+	hc := &Server{mediaDir: t.TempDir(), mediaClient: util.PublicClient(imageTimeout)}
+
+	srvUrl, _ := url.Parse(srv.URL)
+	// Hostname which resolves to loopback is rejected too
+	for _, rawUrl := range []string{srv.URL + "/a.png", "http://localhost:" + srvUrl.Port() + "/a.png"} {
+		u, _ := url.Parse(rawUrl)
+		if _, err := hc.cacheImage(context.Background(), u); err == nil {
+			t.Errorf("cacheImage(%s) succeeded, want error", rawUrl)
+		}
+	}
+	if requested {
+		t.Errorf("private address is requested")
+	}
+}
+
+func TestMediaDirOnlyWithProxyImages(t *testing.T) {
+	dataDir := t.TempDir()
+	hc := newTestServer(t, dataDir)
+	defer hc.Shutdown()
+	if _, err := os.Stat(filepath.Join(dataDir, "media")); !os.IsNotExist(err) {
+		t.Errorf("media directory is created without proxy_images")
+	}
+	if removed, err := hc.cleanupMedia(); removed != 0 || err != nil {
+		t.Errorf("cleanupMedia = %d, %v, want 0 without error", removed, err)
+	}
+}
+
+func TestProxyImagesRemovesOtherCandidates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+	hc := newTestServer(t, t.TempDir())
+	defer hc.Shutdown()
+	// Test server is on loopback address
+	hc.mediaDir = t.TempDir()
+	hc.mediaClient = http.DefaultClient
+
+	document := `<html><body>` +
+		`<picture><source srcset="` + srv.URL + `/a.webp 1x, ` + srv.URL + `/a@2x.webp 2x" type="image/webp"><img src="` + srv.URL + `/a.png" srcset="` + srv.URL + `/a@2x.png 2x"></picture>` +
+		`<picture><source srcset="` + srv.URL + `/b.webp"><img src="` + srv.URL + `/missing.png"></picture>` +
+		`</body></html>`
+	html := hc.proxyImages(document)
+
+	if strings.Contains(html, "/a.webp") || strings.Contains(html, "/a@2x") {
+		t.Errorf("other candidates of proxied image are kept: %s", html)
+	}
+	if !strings.Contains(html, `src="http://feed.example.com/media/`) {
+		t.Errorf("image is not proxied: %s", html)
+	}
+	// Images which cannot be downloaded are kept as is
+	if !strings.Contains(html, `<source srcset="`+srv.URL+`/b.webp"/>`) || !strings.Contains(html, srv.URL+"/missing.png") {
+		t.Errorf("image which is not proxied is changed: %s", html)
+	}
+}
@@ -0,0 +1,86 @@
+//
+// sign.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package http_server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teerapap/feed-to-pocket/internal/util"
+)
+
+// Length of content key in hex
+const keyLength = 32
+
+// loadSecret resolves the configured secret. If it is not configured, a random secret is generated once
+// and kept in the file so content urls are the same across runs.
+func loadSecret(value string, path string) ([]byte, error) {
+	if value != "" {
+		secret, err := util.ResolveValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("http_server.secret: %w", err)
+		}
+		return []byte(secret), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return []byte(strings.TrimSpace(string(data))), nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading secret file: %w", err)
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("generating secret: %w", err)
+	}
+	secret := hex.EncodeToString(b)
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("writing secret file: %w", err)
+	}
+	return []byte(secret), nil
+}
+
+func (hc *Server) mac(parts ...string) string {
+	mac := hmac.New(sha256.New, hc.secret)
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// contentKey is the unguessable key of the content id. It is the same across runs.
+func (hc *Server) contentKey(id string) string {
+	return hc.mac("key", id)[:keyLength]
+}
+
+// sign returns the signature of the content key and its expiry.
+func (hc *Server) sign(key string, expiry time.Time) string {
+	return hc.mac("content", key, strconv.FormatInt(expiry.Unix(), 10))
+}
+
+// verify checks the signature and expiry of the content url.
+func (hc *Server) verify(key string, exp string, sig string, now time.Time) error {
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expiry")
+	}
+	expiry := time.Unix(unix, 0)
+	if !hmac.Equal([]byte(sig), []byte(hc.sign(key, expiry))) {
+		return fmt.Errorf("invalid signature")
+	}
+	if now.After(expiry) {
+		return fmt.Errorf("expired at %s", expiry.Format(time.DateTime))
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+//
+// sign_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package http_server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+)
+
+func init() {
+	log.Initialize(io.Discard)
+}
+
+// tamper changes the first character of the hex signature.
+func tamper(sig string) string {
+	if sig[0] == '0' {
+		return "1" + sig[1:]
+	}
+	return "0" + sig[1:]
+}
+
+func TestVerify(t *testing.T) {
+	hc := &Server{secret: []byte("test-secret")}
+	now := time.Now()
+	key := hc.contentKey("https://example.com/a")
+	expiry := now.Add(time.Hour)
+	exp := strconv.FormatInt(expiry.Unix(), 10)
+	sig := hc.sign(key, expiry)
+
+	tests := []struct {
+		name    string
+		key     string
+		exp     string
+		sig     string
+		now     time.Time
+		wantErr bool
+	}{
+		{"round trip", key, exp, sig, now, false},
+		{"tampered sig", key, exp, tamper(sig), now, true},
+		{"empty sig", key, exp, "", now, true},
+		{"tampered exp", key, strconv.FormatInt(expiry.Unix()+3600, 10), sig, now, true},
+		{"other key", hc.contentKey("https://example.com/b"), exp, sig, now, true},
+		{"expired", key, exp, sig, expiry.Add(time.Second), true},
+		{"non-numeric exp", key, "tomorrow", sig, now, true},
+		{"empty exp", key, "", sig, now, true},
+	}
+	for _, tt := range tests {
+		err := hc.verify(tt.key, tt.exp, tt.sig, tt.now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: verify error = %v, want error %t", tt.name, err, tt.wantErr)
+		}
+	}
+
+	other := &Server{secret: []byte("other-secret")}
+	if err := other.verify(key, exp, sig, now); err == nil {
+		t.Errorf("verify with other secret succeeded")
+	}
+}
+
+func newTestServer(t *testing.T, dataDir string) *Server {
+	hc, err := NewServer(Config{
+		ListenAddr: "127.0.0.1:0",
+		BaseUrl:    "http://feed.example.com",
+	}, dataDir)
+	if err != nil {
+		t.Fatalf("starting server: %s", err)
+	}
+	return hc
+}
+
+func get(hc *Server, rawUrl string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	hc.Srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", rawUrl, nil))
+	return w
+}
+
+func TestContentUrlAcrossRestart(t *testing.T) {
+	dataDir := t.TempDir()
+	id := "https://example.com/a"
+	document := "<html>document</html>"
+
+	// Secret is generated and persisted in data directory
+	hc := newTestServer(t, dataDir)
+	fullUrl := hc.ServeContent(id, document).FullUrl
+	if err := hc.Shutdown(); err != nil {
+		t.Fatalf("shutting down: %s", err)
+	}
+
+	hc = newTestServer(t, dataDir)
+	defer hc.Shutdown()
+	if got := hc.ContentUrl(id); got != fullUrl {
+		t.Errorf("url after restart = %s, want %s", got, fullUrl)
+	}
+	if w := get(hc, fullUrl); w.Code != http.StatusOK || w.Body.String() != document {
+		t.Errorf("GET stored content = %d %q, want 200 %q", w.Code, w.Body.String(), document)
+	}
+
+	// Tampered urls are not found
+	u, _ := url.Parse(fullUrl)
+	query := u.Query()
+	query.Set("sig", tamper(query.Get("sig")))
+	u.RawQuery = query.Encode()
+	if w := get(hc, u.String()); w.Code != http.StatusNotFound {
+		t.Errorf("GET with tampered sig = %d, want 404", w.Code)
+	}
+	u.RawQuery = ""
+	if w := get(hc, u.String()); w.Code != http.StatusNotFound {
+		t.Errorf("GET without signature = %d, want 404", w.Code)
+	}
+}
+
+func TestConfiguredSecret(t *testing.T) {
+	t.Setenv("CONTENT_SECRET_TEST", "configured-secret")
+	path := t.TempDir() + "/content_secret"
+	secret, err := loadSecret("env:CONTENT_SECRET_TEST", path)
+	if err != nil {
+		t.Fatalf("loading secret: %s", err)
+	}
+	if string(secret) != "configured-secret" {
+		t.Errorf("secret = %q, want configured-secret", secret)
+	}
+
+	generated, err := loadSecret("", path)
+	if err != nil {
+		t.Fatalf("generating secret: %s", err)
+	}
+	loaded, err := loadSecret("", path)
+	if err != nil {
+		t.Fatalf("loading generated secret: %s", err)
+	}
+	if len(generated) == 0 || string(generated) != string(loaded) {
+		t.Errorf("loaded secret %q is not the generated secret %q", loaded, generated)
+	}
+}
@@ -0,0 +1,157 @@
+//
+// status.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package http_server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+)
+
+// ContentStatus is the status of a served content.
+type ContentStatus struct {
+	Id           string     `json:"id,omitempty"`
+	Key          string     `json:"key"`
+	InMemory     bool       `json:"in_memory"`
+	Fetched      bool       `json:"fetched"`
+	Fetches      int        `json:"fetches"`
+	ServedAt     *time.Time `json:"served_at,omitempty"`
+	FirstFetched *time.Time `json:"first_fetched,omitempty"`
+	LastFetched  *time.Time `json:"last_fetched,omitempty"`
+}
+
+// Status is the status of the content server.
+type Status struct {
+	Listen   string          `json:"listen"`
+	BaseUrl  string          `json:"base_url"`
+	Contents []ContentStatus `json:"contents"`
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Status returns the status of the server with the contents sorted by served time.
+func (hc *Server) Status() Status {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	status := Status{
+		Listen:   hc.Config.ListenAddr,
+		BaseUrl:  hc.Config.BaseUrl,
+		Contents: make([]ContentStatus, 0, len(hc.contents)),
+	}
+	for _, c := range hc.contents {
+		status.Contents = append(status.Contents, ContentStatus{
+			Id:           c.Id,
+			Key:          c.key,
+			InMemory:     c.Document != "",
+			Fetched:      c.fetches > 0,
+			Fetches:      c.fetches,
+			ServedAt:     timePtr(c.servedAt),
+			FirstFetched: timePtr(c.firstFetched),
+			LastFetched:  timePtr(c.lastFetched),
+		})
+	}
+	sort.Slice(status.Contents, func(i, j int) bool {
+		a, b := status.Contents[i], status.Contents[j]
+		if a.ServedAt == nil || b.ServedAt == nil {
+			return b.ServedAt == nil && a.ServedAt != nil
+		}
+		return a.ServedAt.After(*b.ServedAt)
+	})
+	return status
+}
+
+// handleHealthz reports that the server is alive.
+func (hc *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports whether the server can serve contents.
+func (hc *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if hc.stopping.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if _, err := os.Stat(hc.store.dir); err != nil {
+		log.Errorf("checking content directory: %s", err)
+		http.Error(w, "content store is not available", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+var statusTmpl = template.Must(template.New("status").Funcs(template.FuncMap{
+	"datetime": func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Local().Format(time.DateTime)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8" />
+		<title>feed-to-pocket content server</title>
+	</head>
+	<body>
+		<h2>Content server</h2>
+		<p>Listen: {{ .Listen }}<br/>Base URL: {{ .BaseUrl }}</p>
+		<table border="1">
+			<tr><th>Id</th><th>Key</th><th>In memory</th><th>Fetches</th><th>Served</th><th>First fetched</th><th>Last fetched</th></tr>
+			{{- range .Contents }}
+			<tr><td>{{ .Id }}</td><td>{{ .Key }}</td><td>{{ .InMemory }}</td><td>{{ .Fetches }}</td><td>{{ datetime .ServedAt }}</td><td>{{ datetime .FirstFetched }}</td><td>{{ datetime .LastFetched }}</td></tr>
+			{{- end }}
+		</table>
+	</body>
+</html>
+`))
+
+// authorizedStatus checks the status token in bearer token or basic auth password.
+// Basic auth lets a web browser prompt for the token.
+func (hc *Server) authorizedStatus(r *http.Request) bool {
+	token, isBearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !isBearer {
+		_, token, _ = r.BasicAuth()
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(hc.statusToken)) == 1
+}
+
+// handleStatus shows the status in HTML, or in JSON with ?format=json or Accept: application/json.
+// It requires the status token.
+func (hc *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !hc.authorizedStatus(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="feed-to-pocket status"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	status := hc.Status()
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Errorf("encoding status: %s", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTmpl.Execute(w, status); err != nil {
+		log.Errorf("rendering status: %s", err)
+	}
+}
@@ -0,0 +1,59 @@
+//
+// status_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package http_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusRequiresToken(t *testing.T) {
+	t.Setenv("STATUS_TEST_TOKEN", "status-token")
+	hc, err := NewServer(Config{
+		ListenAddr:  "127.0.0.1:0",
+		BaseUrl:     "http://feed.example.com",
+		StatusToken: "env:STATUS_TEST_TOKEN",
+	}, t.TempDir())
+	if err != nil {
+		t.Fatalf("starting server: %s", err)
+	}
+	defer hc.Shutdown()
+
+	tests := []struct {
+		name   string
+		path   string
+		auth   func(r *http.Request)
+		status int
+	}{
+		{"no token", "/status", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"wrong bearer token", "/status", func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, http.StatusUnauthorized},
+		{"wrong basic auth", "/status", func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }, http.StatusUnauthorized},
+		{"bearer token", "/status", func(r *http.Request) { r.Header.Set("Authorization", "Bearer status-token") }, http.StatusOK},
+		{"basic auth", "/status?format=json", func(r *http.Request) { r.SetBasicAuth("admin", "status-token") }, http.StatusOK},
+		{"healthz is public", "/healthz", func(r *http.Request) {}, http.StatusOK},
+		{"readyz is public", "/readyz", func(r *http.Request) {}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", tt.path, nil)
+		tt.auth(r)
+		hc.Srv.Handler.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: GET %s = %d, want %d", tt.name, tt.path, w.Code, tt.status)
+		}
+	}
+}
+
+func TestStatusNotServedWithoutToken(t *testing.T) {
+	hc := newTestServer(t, t.TempDir())
+	defer hc.Shutdown()
+	if w := get(hc, "/status"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,145 @@
+//
+// store.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package http_server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const DefaultRetention = 30 * 24 * time.Hour
+
+// Temp files older than this are left by interrupted saves
+const tmpGracePeriod = 10 * time.Minute
+
+// Store keeps served documents on disk so they can still be served after the run ends.
+// A document expires after the retention since it was first stored.
+type Store struct {
+	dir       string
+	retention time.Duration
+}
+
+func NewStore(dir string, retention time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("creating content directory: %w", err)
+	}
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &Store{
+		dir:       dir,
+		retention: retention,
+	}, nil
+}
+
+// validKey checks that the key is a plain file name so it cannot escape the store directory.
+func validKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, `/\.`)
+}
+
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, key+".html")
+}
+
+// StoredAt returns when the document of the key was first stored. It returns false if it does not exist or is expired.
+func (s *Store) StoredAt(key string) (time.Time, bool) {
+	if !validKey(key) {
+		return time.Time{}, false
+	}
+	fi, err := os.Stat(s.path(key))
+	if err != nil || s.expired(fi, time.Now()) {
+		return time.Time{}, false
+	}
+	return fi.ModTime(), true
+}
+
+// Save writes the document of the key. Its modification time is set to storedAt.
+func (s *Store) Save(key string, document string, storedAt time.Time) error {
+	if !validKey(key) {
+		return fmt.Errorf("invalid content key: %s", key)
+	}
+	// Write to temp file then rename to avoid serving partial document.
+	// The time is set after rename so the time of temp files tells whether they are left by interrupted saves.
+	tmpPath := s.path(key) + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(document), 0640); err != nil {
+		return fmt.Errorf("writing content file: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path(key)); err != nil {
+		return fmt.Errorf("saving content file: %w", err)
+	}
+	if err := os.Chtimes(s.path(key), storedAt, storedAt); err != nil {
+		return fmt.Errorf("setting content file time: %w", err)
+	}
+	return nil
+}
+
+// Load reads the document of the key. It returns false if it does not exist or is expired.
+func (s *Store) Load(key string) (string, bool, error) {
+	if !validKey(key) {
+		return "", false, nil
+	}
+	fi, err := os.Stat(s.path(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if s.expired(fi, time.Now()) {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(s.path(key))
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), true, nil
+}
+
+func (s *Store) expired(fi os.FileInfo, now time.Time) bool {
+	return fi.ModTime().Add(s.retention).Before(now)
+}
+
+// Cleanup removes expired documents and returns the number of removed documents.
+// Temp files left by interrupted saves are removed too.
+func (s *Store) Cleanup() (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, fmt.Errorf("reading content directory: %w", err)
+	}
+	now := time.Now()
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			if fi.ModTime().Add(tmpGracePeriod).Before(now) {
+				if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil {
+					return removed, fmt.Errorf("removing temp content file: %w", err)
+				}
+			}
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), ".html") || !s.expired(fi, now) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil {
+			return removed, fmt.Errorf("removing expired content: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,77 @@
+//
+// store_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package http_server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreSaveAndLoad(t *testing.T) {
+	s, err := NewStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("creating store: %s", err)
+	}
+	storedAt := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	if err := s.Save("key", "document", storedAt); err != nil {
+		t.Fatalf("saving: %s", err)
+	}
+	if got, ok := s.StoredAt("key"); !ok || !got.Equal(storedAt) {
+		t.Errorf("StoredAt = %s, %t, want %s", got, ok, storedAt)
+	}
+	if doc, found, err := s.Load("key"); err != nil || !found || doc != "document" {
+		t.Errorf("Load = %q, %t, %v, want the document", doc, found, err)
+	}
+	if _, err := os.Stat(s.path("key") + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp file is left after save")
+	}
+	if err := s.Save("../key", "document", storedAt); err == nil {
+		t.Errorf("saving with invalid key succeeded")
+	}
+}
+
+func TestStoreCleanup(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("creating store: %s", err)
+	}
+	now := time.Now()
+	s.Save("expired", "document", now.Add(-2*time.Hour))
+	s.Save("valid", "document", now.Add(-30*time.Minute))
+
+	// Temp files of interrupted saves
+	old := now.Add(-time.Hour)
+	for name, modTime := range map[string]time.Time{"stale.html.tmp": old, "inprogress.html.tmp": now} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("partial"), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := s.Cleanup()
+	if err != nil || removed != 1 {
+		t.Errorf("Cleanup = %d, %v, want 1 expired document", removed, err)
+	}
+	for name, want := range map[string]bool{
+		"expired.html":        false,
+		"valid.html":          true,
+		"stale.html.tmp":      false,
+		"inprogress.html.tmp": true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists = %t, want %t", name, exists, want)
+		}
+	}
+}
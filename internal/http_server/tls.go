@@ -0,0 +1,93 @@
+//
+// tls.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package http_server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+)
+
+// certReloader loads the TLS certificate and reloads it when the files are changed e.g. renewed.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time // latest modification time of the files when they are last loaded, even if not valid
+	// The files cannot be checked since last time. It is logged once.
+	statFailed bool
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if _, err := r.getCertificate(nil); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// getCertificate is tls.Config.GetCertificate. It keeps the current certificate if the new files are not valid.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		if r.cert != nil {
+			if !r.statFailed {
+				log.Errorf("checking TLS certificate: %s", err)
+				r.statFailed = true
+			}
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("checking TLS certificate: %w", err)
+	}
+	r.statFailed = false
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			// Not retried until the files are changed again
+			log.Errorf("reloading TLS certificate: %s. Keep serving the current certificate", err)
+			r.modTime = modTime
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	if r.cert != nil {
+		log.Infof("Reloaded TLS certificate %s", r.certFile)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return r.cert, nil
+}
@@ -0,0 +1,107 @@
+//
+// tls_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package http_server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate of the common name and sets the time of the files.
+func writeCert(t *testing.T, certFile string, keyFile string, name string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modTime)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), modTime)
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, "first", start)
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("loading certificate: %s", err)
+	}
+
+	// Invalid files keep the current certificate and are not loaded again until changed
+	invalidTime := start.Add(time.Minute)
+	writeFile(t, certFile, []byte("invalid"), invalidTime)
+	for i := 0; i < 2; i++ {
+		cert, err := r.getCertificate(nil)
+		if err != nil || commonName(t, cert) != "first" {
+			t.Fatalf("certificate after invalid files = %v, want the first certificate", err)
+		}
+	}
+	if !r.modTime.Equal(invalidTime) {
+		t.Errorf("modTime = %s, want time of the invalid files %s", r.modTime, invalidTime)
+	}
+
+	// Valid new files are loaded
+	writeCert(t, certFile, keyFile, "second", start.Add(2*time.Minute))
+	cert, err := r.getCertificate(nil)
+	if err != nil || commonName(t, cert) != "second" {
+		t.Errorf("certificate after renewal = %v, want the second certificate", err)
+	}
+
+	// Missing files keep the current certificate
+	os.Remove(certFile)
+	cert, err = r.getCertificate(nil)
+	if err != nil || commonName(t, cert) != "second" {
+		t.Errorf("certificate after removal = %v, want the second certificate", err)
+	}
+	if !r.statFailed {
+		t.Errorf("failed check of the files is not recorded")
+	}
+}
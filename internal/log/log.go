@@ -12,6 +12,7 @@ import (
 	"io"
 	"log"
 	"strings"
+	"sync"
 )
 
 var verbose bool
@@ -20,6 +21,9 @@ func SetVerbose(enabled bool) {
 	verbose = enabled
 }
 
+// Guards the indentation and writing of log entries from multiple goroutines
+var mu sync.Mutex
+
 var indentLevel int = 0
 var indent string
 var newlineAfterUnindent = false
@@ -31,10 +35,18 @@ func Initialize(out io.Writer) {
 }
 
 func IndentLevel() int {
+	mu.Lock()
+	defer mu.Unlock()
 	return indentLevel
 }
 
 func SetIndentLevel(level int) {
+	mu.Lock()
+	defer mu.Unlock()
+	setIndentLevel(level)
+}
+
+func setIndentLevel(level int) {
 	if level != indentLevel {
 		if level < indentLevel && newlineAfterUnindent {
 			logger.Println("")
@@ -46,32 +58,41 @@ func SetIndentLevel(level int) {
 }
 
 func Indent() {
-	SetIndentLevel(indentLevel + 1)
+	mu.Lock()
+	defer mu.Unlock()
+	setIndentLevel(indentLevel + 1)
 }
 
 func Unindent() {
-	SetIndentLevel(indentLevel - 1)
+	mu.Lock()
+	defer mu.Unlock()
+	setIndentLevel(indentLevel - 1)
 }
 
 func write(level string, format string, v ...any) {
+	mu.Lock()
+	defer mu.Unlock()
+	writeLocked(level, format, v...)
+}
+
+func writeLocked(level string, format string, v ...any) {
 	logger.SetPrefix(level + indent)
 	logger.Printf(format+"\n", v...)
 	newlineAfterUnindent = true
 }
 
 func Verbose(str string) {
-	Verbosef(str)
+	Verbosef("%s", str)
 }
 
 func Verbosef(format string, v ...any) {
 	if verbose {
 		write("[V] ", format, v...)
-		newlineAfterUnindent = true
 	}
 }
 
 func Print(str string) {
-	Printf(str)
+	Printf("%s", str)
 }
 
 func Printf(format string, v ...any) {
@@ -79,7 +100,7 @@ func Printf(format string, v ...any) {
 }
 
 func Info(str string) {
-	Infof(str)
+	Infof("%s", str)
 }
 
 func Infof(format string, v ...any) {
@@ -87,7 +108,7 @@ func Infof(format string, v ...any) {
 }
 
 func Warn(str string) {
-	Warnf(str)
+	Warnf("%s", str)
 }
 
 func Warnf(format string, v ...any) {
@@ -95,7 +116,7 @@ func Warnf(format string, v ...any) {
 }
 
 func Error(str string) {
-	Errorf(str)
+	Errorf("%s", str)
 }
 
 func Errorf(format string, v ...any) {
@@ -103,7 +124,7 @@ func Errorf(format string, v ...any) {
 }
 
 func Panic(str string) {
-	Panicf(str)
+	Panicf("%s", str)
 }
 
 func Panicf(format string, v ...any) {
@@ -111,3 +132,107 @@ func Panicf(format string, v ...any) {
 	s := fmt.Sprintf(format, v...)
 	panic(s)
 }
+
+// Logger writes log entries with its own indentation.
+// It is either the global log (Std) or a Buffer.
+type Logger interface {
+	Verbosef(format string, v ...any)
+	Printf(format string, v ...any)
+	Infof(format string, v ...any)
+	Warnf(format string, v ...any)
+	Errorf(format string, v ...any)
+	Indent()
+	Unindent()
+}
+
+// Std writes to the global log.
+var Std Logger = stdLogger{}
+
+type stdLogger struct{}
+
+func (stdLogger) Verbosef(format string, v ...any) { Verbosef(format, v...) }
+func (stdLogger) Printf(format string, v ...any)   { Printf(format, v...) }
+func (stdLogger) Infof(format string, v ...any)    { Infof(format, v...) }
+func (stdLogger) Warnf(format string, v ...any)    { Warnf(format, v...) }
+func (stdLogger) Errorf(format string, v ...any)   { Errorf(format, v...) }
+func (stdLogger) Indent()                          { Indent() }
+func (stdLogger) Unindent()                        { Unindent() }
+
+// Buffer holds log entries to be written later in order.
+// It is useful for work done in another goroutine.
+// A Buffer is not safe for concurrent use.
+type Buffer struct {
+	indentLevel int
+	entries     []bufferEntry
+}
+
+type bufferEntry struct {
+	level       string
+	indentLevel int
+	msg         string
+}
+
+func (b *Buffer) add(level string, format string, v ...any) {
+	b.entries = append(b.entries, bufferEntry{
+		level:       level,
+		indentLevel: b.indentLevel,
+		msg:         fmt.Sprintf(format, v...),
+	})
+}
+
+func (b *Buffer) Indent() {
+	b.indentLevel++
+}
+
+func (b *Buffer) Unindent() {
+	b.indentLevel--
+}
+
+func (b *Buffer) Verbosef(format string, v ...any) {
+	if verbose {
+		b.add("[V] ", format, v...)
+	}
+}
+
+func (b *Buffer) Printf(format string, v ...any) {
+	b.Infof(format, v...)
+}
+
+func (b *Buffer) Infof(format string, v ...any) {
+	b.add("[I] ", format, v...)
+}
+
+func (b *Buffer) Warnf(format string, v ...any) {
+	b.add("[W] ", format, v...)
+}
+
+func (b *Buffer) Errorf(format string, v ...any) {
+	b.add("[E] ", format, v...)
+}
+
+// Flush writes all buffered entries relative to the current indentation level.
+func (b *Buffer) Flush() {
+	mu.Lock()
+	defer mu.Unlock()
+	level := indentLevel
+	defer setIndentLevel(level)
+	for _, e := range b.entries {
+		setIndentLevel(level + e.indentLevel)
+		writeLocked(e.level, "%s", e.msg)
+	}
+	b.entries = nil
+}
+
+// FlushTo writes all buffered entries into the logger relative to its current indentation.
+func (b *Buffer) FlushTo(l Logger) {
+	dst, ok := l.(*Buffer)
+	if !ok {
+		b.Flush()
+		return
+	}
+	for _, e := range b.entries {
+		e.indentLevel += dst.indentLevel
+		dst.entries = append(dst.entries, e)
+	}
+	b.entries = nil
+}
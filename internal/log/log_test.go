@@ -0,0 +1,69 @@
+//
+// log_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package log
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBufferFlushKeepsNesting(t *testing.T) {
+	var out bytes.Buffer
+	Initialize(&out)
+	logger.SetFlags(0)
+
+	// Buffers written concurrently are flushed in one piece with their own indentation
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var fetched Buffer
+			fetched.Printf("fetched %s", name)
+
+			var logs Buffer
+			logs.Printf("source %s", name)
+			logs.Indent()
+			fetched.FlushTo(&logs)
+			logs.Printf("item %s", name)
+			logs.Indent()
+			logs.Printf("delivered %s", name)
+			logs.Unindent()
+			logs.Unindent()
+			logs.Printf("done %s", name)
+			logs.Flush()
+		}()
+	}
+	wg.Wait()
+
+	// Skip empty lines after unindent
+	lines := make([]string, 0)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.TrimSpace(strings.TrimPrefix(line, "[I]")) != "" {
+			lines = append(lines, line)
+		}
+	}
+	got := strings.Join(lines, "\n")
+	for _, name := range []string{"a", "b"} {
+		want := strings.Join([]string{
+			"[I] source " + name,
+			"[I]     fetched " + name,
+			"[I]     item " + name,
+			"[I]         delivered " + name,
+			"[I] done " + name,
+		}, "\n")
+		if !strings.Contains(got, want) {
+			t.Errorf("log of %s is not written in one piece:\n%s\nwant:\n%s", name, got, want)
+		}
+	}
+	if IndentLevel() != 0 {
+		t.Errorf("indent level = %d after flush, want 0", IndentLevel())
+	}
+}
@@ -0,0 +1,222 @@
+//
+// list.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package pocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+	"github.com/teerapap/feed-to-pocket/internal/util"
+)
+
+// Number of items to retrieve in one request
+const listPageSize = 100
+
+// Item status in Pocket list
+const statusDeleted = "2"
+
+// savedList is a local copy of the urls in the Pocket list, both unread and archived.
+// It is synced incrementally with the since parameter and persisted as a json file in the data directory.
+type savedList struct {
+	path  string
+	Since int64 `json:"since"`
+	// Normalized urls of each Pocket item id
+	Items map[string][]string `json:"items"`
+	urls  map[string]bool
+}
+
+func openSavedList(path string) (*savedList, error) {
+	list := &savedList{
+		path:  path,
+		Items: make(map[string][]string),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return list, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("parsing saved list file: %w", err)
+	}
+	if list.Items == nil {
+		list.Items = make(map[string][]string)
+	}
+	return list, nil
+}
+
+func (l *savedList) index() {
+	l.urls = make(map[string]bool, len(l.Items))
+	for _, urls := range l.Items {
+		for _, u := range urls {
+			l.urls[u] = true
+		}
+	}
+}
+
+func (l *savedList) save() error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("encoding saved list: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0750); err != nil {
+		return err
+	}
+	tmpPath := l.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0640); err != nil {
+		return fmt.Errorf("writing saved list file: %w", err)
+	}
+	return os.Rename(tmpPath, l.path)
+}
+
+// listItem is an item in the response of /v3/get
+type listItem struct {
+	ItemId      string `json:"item_id"`
+	GivenUrl    string `json:"given_url"`
+	ResolvedUrl string `json:"resolved_url"`
+	Status      string `json:"status"`
+}
+
+// Existing reports which of the urls are already saved or archived in the Pocket list.
+// It syncs the local copy of the list with the changes since last time first.
+func (c *Client) Existing(ctx context.Context, urls []string) ([]bool, error) {
+	if c.saved == nil {
+		list, err := openSavedList(filepath.Join(c.dataDir, "pocket_list.json"))
+		if err != nil {
+			return nil, fmt.Errorf("reading saved list: %w", err)
+		}
+		c.saved = list
+	}
+	if err := c.syncList(ctx); err != nil {
+		return nil, fmt.Errorf("retrieving Pocket list: %w", err)
+	}
+
+	exists := make([]bool, len(urls))
+	for i, u := range urls {
+		exists[i] = c.saved.urls[normalizeUrl(u)]
+	}
+	return exists, nil
+}
+
+func (c *Client) syncList(ctx context.Context) error {
+	consumerKey, err := util.ResolveValue(c.Config.ConsumerKey)
+	if err != nil {
+		return fmt.Errorf("pocket.consumer_key: %w", err)
+	}
+	accessToken, err := util.ResolveValue(c.Config.AccessToken)
+	if err != nil {
+		return fmt.Errorf("pocket.access_token: %w", err)
+	}
+	log.Printf("Retrieving Pocket list changes since %d", c.saved.Since)
+	var since int64
+	changed := 0
+	for offset := 0; ; offset = offset + listPageSize {
+		// A large list takes many pages
+		if err := c.waitForQuota(ctx); err != nil {
+			return err
+		}
+		body := map[string]any{
+			"consumer_key": consumerKey,
+			"access_token": accessToken,
+			"state":        "all",
+			"detailType":   "simple",
+			"count":        listPageSize,
+			"offset":       offset,
+		}
+		if c.saved.Since > 0 {
+			body["since"] = c.saved.Since
+		}
+		var resp struct {
+			Since int64 `json:"since"`
+			// It is an empty array instead of object if there is no item
+			List json.RawMessage `json:"list"`
+		}
+		if err := c.apiRequest("/v3/get", body, &resp); err != nil {
+			return err
+		}
+		if since == 0 {
+			since = resp.Since
+		}
+
+		if isEmptyList(resp.List) {
+			break
+		}
+		var items map[string]listItem
+		if err := json.Unmarshal(resp.List, &items); err != nil {
+			// Keep the saved list and since as is so the changes are retrieved again next time
+			return fmt.Errorf("decoding list page at offset %d: %w", offset, err)
+		}
+		if len(items) == 0 {
+			break
+		}
+		for id, item := range items {
+			if item.Status == statusDeleted {
+				delete(c.saved.Items, id)
+				continue
+			}
+			urls := []string{normalizeUrl(item.GivenUrl)}
+			if item.ResolvedUrl != "" && item.ResolvedUrl != item.GivenUrl {
+				urls = append(urls, normalizeUrl(item.ResolvedUrl))
+			}
+			c.saved.Items[id] = urls
+		}
+		changed = changed + len(items)
+	}
+	log.Printf("Retrieved %d changed items. Total %d items in Pocket list", changed, len(c.saved.Items))
+
+	c.saved.Since = since
+	c.saved.index()
+	if err := c.saved.save(); err != nil {
+		log.Errorf("saving Pocket list: %s", err)
+	}
+	return nil
+}
+
+// isEmptyList checks whether the list of a page has no item. Pocket returns an empty array instead of object.
+func isEmptyList(list json.RawMessage) bool {
+	switch strings.TrimSpace(string(list)) {
+	case "", "null", "[]":
+		return true
+	}
+	return false
+}
+
+// normalizeUrl normalizes the url for comparison.
+// Scheme, www., default port, fragment, trailing slash and utm_* tracking parameters are ignored.
+func normalizeUrl(rawUrl string) string {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil || u.Host == "" {
+		return strings.TrimSpace(rawUrl)
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host = host + ":" + port
+	}
+
+	query := u.Query()
+	for key := range query {
+		if strings.HasPrefix(key, "utm_") {
+			query.Del(key)
+		}
+	}
+
+	normalized := host + strings.TrimSuffix(u.EscapedPath(), "/")
+	if len(query) > 0 {
+		// Encode sorts by key
+		normalized = normalized + "?" + query.Encode()
+	}
+	return normalized
+}
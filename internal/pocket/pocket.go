@@ -9,29 +9,91 @@ package pocket
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/teerapap/feed-to-pocket/internal/log"
+	"github.com/teerapap/feed-to-pocket/internal/sink"
+	"github.com/teerapap/feed-to-pocket/internal/util"
 )
 
+const defaultBaseUrl = "https://getpocket.com"
+
+const (
+	defaultTimeout      = 30 * time.Second
+	defaultRetryBackoff = 1 * time.Second
+	defaultMaxWait      = 1 * time.Minute
+)
+
+// Config of Pocket client. Keys can be loaded with "env:NAME" or "file:PATH".
 type Config struct {
-	ConsumerKey string `toml:"consumer_key"`
-	AccessToken string `toml:"access_token"`
-	Batch       int    `toml:"batch"`
+	// Pocket API base url. It can point to a fake Pocket server for testing.
+	BaseUrl string `toml:"base_url,omitempty"`
+	// Proxy url of API requests. Default is from HTTP_PROXY/HTTPS_PROXY environment variables.
+	Proxy        string        `toml:"proxy,omitempty"`
+	Timeout      time.Duration `toml:"timeout,omitempty"`
+	ConsumerKey  string        `toml:"consumer_key"`
+	AccessToken  string        `toml:"access_token"`
+	Batch        int           `toml:"batch"`
+	Retries      int           `toml:"retries,omitempty"`
+	RetryBackoff time.Duration `toml:"retry_backoff,omitempty"`
+	// Longest time to wait for the rate limit to reset. Items are deferred to next run if it takes longer.
+	MaxWait time.Duration `toml:"max_wait,omitempty"`
+	// Skip items already saved or archived in the Pocket list
+	SkipExisting bool `toml:"skip_existing,omitempty"`
 }
 
 type Client struct {
-	Config Config
+	Config         Config
+	baseUrl        *url.URL
+	client         *http.Client
+	dataDir        string
+	rateLimit      RateLimit
+	rateLimitKnown bool
+	saved          *savedList // nil until first retrieved
 }
 
-func NewClient(config Config) (*Client, error) {
+var errRateLimited = errors.New("rate limit is exhausted")
+
+func NewClient(config Config, dataDir string) (*Client, error) {
+	config.Retries = max(0, config.Retries)
+	config.RetryBackoff = cmp.Or(config.RetryBackoff, defaultRetryBackoff)
+	config.MaxWait = cmp.Or(config.MaxWait, defaultMaxWait)
+	config.Timeout = cmp.Or(config.Timeout, defaultTimeout)
+	config.BaseUrl = cmp.Or(config.BaseUrl, defaultBaseUrl)
+
+	baseUrl, err := url.Parse(config.BaseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("pocket.base_url is not valid: %w", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Proxy != "" {
+		proxyUrl, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("pocket.proxy is not valid: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
 	return &Client{
-		Config: config,
+		Config:  config,
+		baseUrl: baseUrl,
+		client:  &http.Client{Timeout: config.Timeout, Transport: transport},
+		dataDir: dataDir,
 	}, nil
 }
 
+// RateLimit returns the last known quota. It returns false if it is not known yet.
+func (c *Client) RateLimit() (RateLimit, bool) {
+	return c.rateLimit, c.rateLimitKnown
+}
+
 type NewItem struct {
 	Url   string   `json:"url"`
 	Title string   `json:"title,omitempty"`
@@ -51,68 +113,291 @@ func (item NewItem) MarshalJSON() ([]byte, error) {
 	})
 }
 
-func (c *Client) AddItems(items []NewItem) error {
+func (c *Client) Name() string {
+	return "Pocket"
+}
+
+func (c *Client) AddItems(ctx context.Context, items []sink.Item) []sink.Result {
+	results := make([]sink.Result, 0, len(items))
 	if len(items) == 0 {
-		return nil
-	}
-	batch := c.Config.Batch
-	if batch <= 0 {
-		batch = 20
+		return results
 	}
 	log.Printf("Adding %d new items to Pocket", len(items))
 
-	for i := 0; i < len(items); i = i + batch {
-		bItems := items[i:min(i+batch, len(items))]
-		if batch <= len(items) {
-			log.Printf("(Batch %d) %d items", (i/batch)+1, len(bItems))
+	consumerKey, err := util.ResolveValue(c.Config.ConsumerKey)
+	if err != nil {
+		return sink.Results(items, fmt.Errorf("pocket.consumer_key: %w", err))
+	}
+	accessToken, err := util.ResolveValue(c.Config.AccessToken)
+	if err != nil {
+		return sink.Results(items, fmt.Errorf("pocket.access_token: %w", err))
+	}
+
+	batches := sink.Batches(items, c.Config.Batch)
+	for i, bItems := range batches {
+		if len(batches) > 1 {
+			log.Printf("(Batch %d) %d items", i+1, len(bItems))
+		}
+		if err := c.waitForQuota(ctx); err != nil {
+			// Defer the remaining batches to next run
+			log.Warnf("%s. Deferring %d items to next run", err, len(items)-len(results))
+			return append(results, sink.Results(items[len(results):], err)...)
+		}
+		actions := make([]NewItem, 0, len(bItems))
+		for _, item := range bItems {
+			actions = append(actions, NewItem{
+				Url:   item.Url,
+				Title: item.Title,
+				Time:  item.Time.Unix(),
+				Tags:  item.Tags,
+			})
 		}
 		body := struct {
 			ConsumerKey string    `json:"consumer_key"`
 			AccessToken string    `json:"access_token"`
 			Actions     []NewItem `json:"actions"`
 		}{
-			ConsumerKey: c.Config.ConsumerKey,
-			AccessToken: c.Config.AccessToken,
-			Actions:     bItems,
+			ConsumerKey: consumerKey,
+			AccessToken: accessToken,
+			Actions:     actions,
 		}
 
 		jsonBody, err := json.Marshal(body)
 		if err != nil {
-			return fmt.Errorf("encoding request in json: %w", err)
+			results = append(results, sink.Results(bItems, fmt.Errorf("encoding request in json: %w", err))...)
+			continue
+		}
+		itemErrs, err := c.sendWithRetry(ctx, jsonBody, len(actions))
+		if err != nil {
+			// Continue with next batches. Failed items are retried next time.
+			log.Errorf("Failed to add batch: %s", err)
+			results = append(results, sink.Results(bItems, err)...)
+			continue
 		}
-		if err := c.send(jsonBody); err != nil {
-			return err
+		for j, item := range bItems {
+			if itemErrs[j] != nil {
+				log.Errorf("Failed to add %s: %s", item.Url, itemErrs[j])
+			}
+			results = append(results, sink.Result{Item: item, Err: itemErrs[j]})
 		}
 	}
 
+	return results
+}
+
+// sendResponse is the response of /v3/send
+type sendResponse struct {
+	Status int `json:"status"`
+	// Result of each action. It is false or null if the action failed.
+	ActionResults []json.RawMessage `json:"action_results"`
+	ActionErrors  []*actionError    `json:"action_errors"`
+}
+
+type actionError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    int    `json:"code"`
+}
+
+func (e *actionError) Error() string {
+	return fmt.Sprintf("%s (type=%s, code=%d)", e.Message, e.Type, e.Code)
+}
+
+// waitForQuota waits until the rate limit resets if the quota is nearly exhausted.
+// It returns error without waiting if the reset is later than the max wait, or if the context is done while waiting.
+func (c *Client) waitForQuota(ctx context.Context) error {
+	if !c.rateLimitKnown {
+		return nil
+	}
+	wait := c.rateLimit.waitTime(time.Now())
+	if wait <= 0 {
+		return nil
+	}
+	if wait > c.Config.MaxWait {
+		return fmt.Errorf("%w until %s", errRateLimited, time.Now().Add(wait).Format(time.DateTime))
+	}
+	log.Warnf("Rate limit is nearly exhausted (%s). Waiting %s", c.rateLimit, wait.Round(time.Second))
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("%w while waiting for rate limit", ctx.Err())
+	case <-time.After(wait):
+	}
 	return nil
 }
 
-func (c *Client) send(jsonBody []byte) error {
+// sendWithRetry sends the actions and retries with exponential backoff on 429 and 503 responses.
+// It stops retrying when the context is done.
+func (c *Client) sendWithRetry(ctx context.Context, jsonBody []byte, numActions int) ([]error, error) {
+	backoff := c.Config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		itemErrs, err := c.send(jsonBody, numActions)
+		if err == nil {
+			return itemErrs, nil
+		}
+
+		var aErr *util.AttemptError
+		if !errors.As(err, &aErr) || !aErr.Retryable || attempt >= c.Config.Retries {
+			return nil, err
+		}
+		wait := backoff
+		if aErr.RetryAfter > 0 {
+			wait = aErr.RetryAfter
+		} else if c.rateLimitKnown {
+			// Wait for the rate limit to reset if it is exhausted
+			wait = max(wait, c.rateLimit.waitTime(time.Now()))
+		}
+		if wait > c.Config.MaxWait {
+			return nil, fmt.Errorf("%w (retry after %s is too long)", err, wait.Round(time.Second))
+		}
+		log.Warnf("Send failed: %s. Retrying in %s (%d/%d)", err, wait.Round(time.Second), attempt+1, c.Config.Retries)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w (retry is stopped: %w)", err, ctx.Err())
+		case <-time.After(wait):
+		}
+		backoff = backoff * 2
+	}
+}
+
+// send sends the actions and returns the error of each action in the same order.
+func (c *Client) send(jsonBody []byte, numActions int) ([]error, error) {
 	log.Indent()
 	defer log.Unindent()
 	log.Verbosef("Request Body: %s", string(jsonBody))
 
-	req, err := http.NewRequest("POST", "https://getpocket.com/v3/send", bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequest("POST", c.baseUrl.JoinPath("v3", "send").String(), bytes.NewBuffer(jsonBody))
 	if err != nil {
-		return fmt.Errorf("creating api request in json: %w", err)
+		return nil, fmt.Errorf("creating api request in json: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("api request error: %w", err)
+		return nil, fmt.Errorf("api request error: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if c.rateLimit.update(resp.Header, time.Now()) {
+		c.rateLimitKnown = true
+		log.Verbosef("Rate limit: %s", c.rateLimit)
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		log.Errorf("Response status code: %d", resp.StatusCode)
 		for key, value := range resp.Header {
-			log.Errorf("Response header[%s]: %s", key, value)
+			log.Verbosef("Response header[%s]: %s", key, value)
+		}
+		// Pocket explains the error in X-Error header
+		return nil, &util.AttemptError{
+			Err:        fmt.Errorf("api response failure: %s %s", resp.Status, resp.Header.Get("X-Error")),
+			Retryable:  resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable,
+			RetryAfter: util.ParseRetryAfter(resp.Header.Get("Retry-After")),
 		}
-		return fmt.Errorf("api response failure")
 	}
 
+	var body sendResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding api response: %w", err)
+	}
+	log.Verbosef("Response Status: %d, Action Results: %d", body.Status, len(body.ActionResults))
+
+	if len(body.ActionResults) != numActions {
+		return nil, fmt.Errorf("api response has %d action results for %d actions", len(body.ActionResults), numActions)
+	}
+
+	errs := make([]error, len(body.ActionResults))
+	for i, result := range body.ActionResults {
+		if r := string(result); r != "false" && r != "null" {
+			continue
+		}
+		if i < len(body.ActionErrors) && body.ActionErrors[i] != nil {
+			errs[i] = body.ActionErrors[i]
+		} else {
+			errs[i] = fmt.Errorf("action failed")
+		}
+	}
+	return errs, nil
+}
+
+// RequestToken obtains a request token to start OAuth authorization.
+func (c *Client) RequestToken(redirectUri string) (string, error) {
+	consumerKey, err := util.ResolveValue(c.Config.ConsumerKey)
+	if err != nil {
+		return "", fmt.Errorf("pocket.consumer_key: %w", err)
+	}
+
+	var resp struct {
+		Code string `json:"code"`
+	}
+	err = c.apiRequest("/v3/oauth/request", map[string]string{
+		"consumer_key": consumerKey,
+		"redirect_uri": redirectUri,
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	return resp.Code, nil
+}
+
+// AuthorizeUrl is the url for user to authorize the request token.
+func (c *Client) AuthorizeUrl(requestToken string, redirectUri string) string {
+	query := url.Values{}
+	query.Set("request_token", requestToken)
+	query.Set("redirect_uri", redirectUri)
+	return c.baseUrl.JoinPath("auth", "authorize").String() + "?" + query.Encode()
+}
+
+// Authorize converts the authorized request token into an access token.
+// It returns the access token and username.
+func (c *Client) Authorize(requestToken string) (string, string, error) {
+	consumerKey, err := util.ResolveValue(c.Config.ConsumerKey)
+	if err != nil {
+		return "", "", fmt.Errorf("pocket.consumer_key: %w", err)
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		Username    string `json:"username"`
+	}
+	err = c.apiRequest("/v3/oauth/authorize", map[string]string{
+		"consumer_key": consumerKey,
+		"code":         requestToken,
+	}, &resp)
+	if err != nil {
+		return "", "", err
+	}
+	return resp.AccessToken, resp.Username, nil
+}
+
+func (c *Client) apiRequest(path string, body any, out any) error {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("encoding request in json: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.baseUrl.JoinPath(path).String(), bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return fmt.Errorf("creating api request in json: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("api request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if c.rateLimit.update(resp.Header, time.Now()) {
+		c.rateLimitKnown = true
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// Pocket explains the error in X-Error header
+		return fmt.Errorf("api response failure: %s %s", resp.Status, resp.Header.Get("X-Error"))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding api response: %w", err)
+	}
 	return nil
 }
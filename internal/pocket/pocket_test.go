@@ -0,0 +1,352 @@
+//
+// pocket_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package pocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teerapap/feed-to-pocket/internal/fakepocket"
+	"github.com/teerapap/feed-to-pocket/internal/log"
+	"github.com/teerapap/feed-to-pocket/internal/sink"
+)
+
+func init() {
+	log.Initialize(io.Discard)
+}
+
+func newTestClient(t *testing.T, baseUrl string, config Config) *Client {
+	config.BaseUrl = baseUrl
+	config.ConsumerKey = "consumer-key"
+	config.AccessToken = "access-token"
+	config.RetryBackoff = time.Millisecond
+	c, err := NewClient(config, t.TempDir())
+	if err != nil {
+		t.Fatalf("creating client: %s", err)
+	}
+	return c
+}
+
+func TestAddItems(t *testing.T) {
+	fake := fakepocket.New()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	c := newTestClient(t, srv.URL, Config{Batch: 2})
+
+	published := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	items := []sink.Item{
+		{Url: "https://example.com/a", Title: "A", Time: published, Tags: []string{"news"}},
+		{Url: "https://example.com/b", Title: "B", Time: published},
+		{Url: "https://example.com/c", Title: "C", Time: published},
+	}
+	results := c.AddItems(context.Background(), items)
+	if got := sink.CountFailed(results); got != 0 {
+		t.Errorf("CountFailed = %d, want 0", got)
+	}
+
+	actions := fake.Actions()
+	if len(actions) != len(items) {
+		t.Fatalf("got %d actions, want %d", len(actions), len(items))
+	}
+	for i, item := range items {
+		a := actions[i]
+		if a.Action != "add" || a.Url != item.Url || a.Title != item.Title || a.Time != published.Unix() {
+			t.Errorf("action %d = %+v, want add of %+v", i, a, item)
+		}
+	}
+	if len(actions[0].Tags) != 1 || actions[0].Tags[0] != "news" {
+		t.Errorf("action 0 tags = %v, want [news]", actions[0].Tags)
+	}
+}
+
+func TestAddItemsActionErrors(t *testing.T) {
+	fake := fakepocket.New()
+	fake.FailUrl("https://example.com/bad")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	c := newTestClient(t, srv.URL, Config{})
+
+	items := []sink.Item{
+		{Url: "https://example.com/a"},
+		{Url: "https://example.com/bad"},
+		{Url: "https://example.com/c"},
+	}
+	results := c.AddItems(context.Background(), items)
+	if len(results) != len(items) {
+		t.Fatalf("got %d results, want %d", len(results), len(items))
+	}
+	for i, wantOk := range []bool{true, false, true} {
+		if results[i].Item.Url != items[i].Url {
+			t.Errorf("result %d is for %s, want %s", i, results[i].Item.Url, items[i].Url)
+		}
+		if results[i].Ok() != wantOk {
+			t.Errorf("result %d ok = %t, want %t (error %v)", i, results[i].Ok(), wantOk, results[i].Err)
+		}
+	}
+	var aErr *actionError
+	if !errors.As(results[1].Err, &aErr) || aErr.Code != 400 {
+		t.Errorf("result 1 error = %v, want action error with code 400", results[1].Err)
+	}
+}
+
+func TestBaseUrlWithPath(t *testing.T) {
+	fake := fakepocket.New()
+	mux := http.NewServeMux()
+	mux.Handle("/pocket/", http.StripPrefix("/pocket", fake))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, baseUrl := range []string{srv.URL + "/pocket", srv.URL + "/pocket/"} {
+		fake.Reset()
+		c := newTestClient(t, baseUrl, Config{})
+		results := c.AddItems(context.Background(), []sink.Item{{Url: "https://example.com/a"}})
+		if !results[0].Ok() {
+			t.Errorf("base url %s: %s", baseUrl, results[0].Err)
+		}
+		if got := len(fake.Actions()); got != 1 {
+			t.Errorf("base url %s: got %d actions, want 1", baseUrl, got)
+		}
+	}
+}
+
+// failFirst responds with the status to the first n requests and passes the others to the handler.
+func failFirst(n int, status int, handler http.Handler) (http.Handler, *int) {
+	var mu sync.Mutex
+	calls := 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		fail := calls <= n
+		mu.Unlock()
+		if fail {
+			w.Header().Set("X-Error", "Try again later")
+			w.WriteHeader(status)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}), &calls
+}
+
+func TestAddItemsRetry(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		fake := fakepocket.New()
+		handler, calls := failFirst(2, status, fake)
+		srv := httptest.NewServer(handler)
+		c := newTestClient(t, srv.URL, Config{Retries: 2})
+
+		results := c.AddItems(context.Background(), []sink.Item{{Url: "https://example.com/a"}})
+		if !results[0].Ok() {
+			t.Errorf("status %d: item failed: %s", status, results[0].Err)
+		}
+		if *calls != 3 {
+			t.Errorf("status %d: got %d calls, want 3", status, *calls)
+		}
+		if got := len(fake.Actions()); got != 1 {
+			t.Errorf("status %d: got %d actions, want 1", status, got)
+		}
+		srv.Close()
+	}
+}
+
+func TestAddItemsRetryExhausted(t *testing.T) {
+	handler, calls := failFirst(10, http.StatusServiceUnavailable, fakepocket.New())
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	c := newTestClient(t, srv.URL, Config{Retries: 1})
+
+	results := c.AddItems(context.Background(), []sink.Item{{Url: "https://example.com/a"}, {Url: "https://example.com/b"}})
+	if got := sink.CountFailed(results); got != 2 {
+		t.Errorf("CountFailed = %d, want 2", got)
+	}
+	if *calls != 2 {
+		t.Errorf("got %d calls, want 2", *calls)
+	}
+}
+
+func TestAddItemsNoRetryOnBadRequest(t *testing.T) {
+	handler, calls := failFirst(1, http.StatusBadRequest, fakepocket.New())
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	c := newTestClient(t, srv.URL, Config{Retries: 3})
+
+	results := c.AddItems(context.Background(), []sink.Item{{Url: "https://example.com/a"}})
+	if results[0].Ok() {
+		t.Errorf("item succeeded, want failure")
+	}
+	if *calls != 1 {
+		t.Errorf("got %d calls, want 1", *calls)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	srv := httptest.NewServer(fakepocket.New())
+	defer srv.Close()
+	c := newTestClient(t, srv.URL, Config{})
+
+	if _, ok := c.RateLimit(); ok {
+		t.Errorf("rate limit is known before any request")
+	}
+	before := time.Now()
+	c.AddItems(context.Background(), []sink.Item{{Url: "https://example.com/a"}})
+	rl, ok := c.RateLimit()
+	if !ok {
+		t.Fatalf("rate limit is not known after a request")
+	}
+	if rl.UserLimit != 10000 || rl.UserRemaining != 9999 || rl.KeyLimit != 10000 || rl.KeyRemaining != 9999 {
+		t.Errorf("rate limit = %s, want 9999/10000", rl)
+	}
+	for _, reset := range []time.Time{rl.UserReset, rl.KeyReset} {
+		if reset.Before(before.Add(time.Hour)) || reset.After(time.Now().Add(time.Hour)) {
+			t.Errorf("reset = %s, want an hour later", reset)
+		}
+	}
+}
+
+func TestRateLimitWaitTime(t *testing.T) {
+	now := time.Now()
+	header := http.Header{}
+	header.Set("X-Limit-User-Limit", "320")
+	header.Set("X-Limit-User-Remaining", "1")
+	header.Set("X-Limit-User-Reset", "30")
+	header.Set("X-Limit-Key-Limit", "10000")
+	header.Set("X-Limit-Key-Remaining", "5000")
+	header.Set("X-Limit-Key-Reset", "600")
+
+	var rl RateLimit
+	if !rl.update(header, now) {
+		t.Fatalf("no rate limit is found in headers")
+	}
+	if got := rl.waitTime(now); got != 30*time.Second {
+		t.Errorf("waitTime = %s, want 30s", got)
+	}
+	if (&RateLimit{}).update(http.Header{}, now) {
+		t.Errorf("rate limit is found without headers")
+	}
+}
+
+func TestExisting(t *testing.T) {
+	fake := fakepocket.New()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	c := newTestClient(t, srv.URL, Config{})
+
+	c.AddItems(context.Background(), []sink.Item{{Url: "https://www.example.com/a/?utm_source=feed"}})
+	found, err := c.Existing(context.Background(), []string{"http://example.com/a", "https://example.com/b"})
+	if err != nil {
+		t.Fatalf("checking existing: %s", err)
+	}
+	if !found[0] || found[1] {
+		t.Errorf("Existing = %v, want [true false]", found)
+	}
+}
+
+func TestAddItemsStopsWaitingOnCancel(t *testing.T) {
+	handler, calls := failFirst(10, http.StatusServiceUnavailable, fakepocket.New())
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	c := newTestClient(t, srv.URL, Config{Retries: 3})
+	c.Config.RetryBackoff = 30 * time.Second
+
+	// Waits for retry backoff
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results := c.AddItems(ctx, []sink.Item{{Url: "https://example.com/a"}})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("AddItems returned after %s, want soon after cancel", elapsed)
+	}
+	if results[0].Ok() || !errors.Is(results[0].Err, context.DeadlineExceeded) {
+		t.Errorf("result error = %v, want stopped by context", results[0].Err)
+	}
+	if *calls != 1 {
+		t.Errorf("got %d calls, want 1", *calls)
+	}
+
+	// Waits for rate limit to reset
+	header := http.Header{}
+	header.Set("X-Limit-User-Limit", "320")
+	header.Set("X-Limit-User-Remaining", "0")
+	header.Set("X-Limit-User-Reset", "30")
+	c.rateLimitKnown = c.rateLimit.update(header, time.Now())
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	results = c.AddItems(ctx, []sink.Item{{Url: "https://example.com/a"}})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("AddItems returned after %s, want soon after cancel", elapsed)
+	}
+	if results[0].Ok() || !errors.Is(results[0].Err, context.DeadlineExceeded) {
+		t.Errorf("result error = %v, want stopped by context", results[0].Err)
+	}
+	if *calls != 1 {
+		t.Errorf("got %d calls, want no request while waiting for rate limit", *calls)
+	}
+}
+
+// newListClient creates a client of which /v3/get responds with the pages in order. It counts the requests.
+func newListClient(t *testing.T, header http.Header, pages ...string) (*Client, *int) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := `{"status":1,"since":100,"list":[]}`
+		if calls < len(pages) {
+			page = pages[calls]
+		}
+		calls++
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+		w.Write([]byte(page))
+	}))
+	t.Cleanup(srv.Close)
+	return newTestClient(t, srv.URL, Config{}), &calls
+}
+
+func TestExistingMalformedPage(t *testing.T) {
+	c, calls := newListClient(t, nil,
+		`{"status":1,"since":100,"list":{"1":{"item_id":"1","given_url":"https://example.com/a","status":"0"}}}`,
+		`{"status":1,"since":100,"list":"oops"}`)
+
+	if _, err := c.Existing(context.Background(), []string{"https://example.com/a"}); err == nil {
+		t.Errorf("Existing succeeded with malformed page, want error")
+	}
+	if *calls != 2 {
+		t.Errorf("got %d calls, want 2", *calls)
+	}
+	// Partial list is not saved so all changes are retrieved again next time
+	if _, err := os.Stat(filepath.Join(c.dataDir, "pocket_list.json")); !os.IsNotExist(err) {
+		t.Errorf("partial list is saved")
+	}
+	if c.saved.Since != 0 {
+		t.Errorf("since = %d after failed sync, want 0", c.saved.Since)
+	}
+}
+
+func TestExistingWaitsForQuotaOfEachPage(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Limit-User-Limit", "320")
+	header.Set("X-Limit-User-Remaining", "0")
+	header.Set("X-Limit-User-Reset", "3600")
+	c, calls := newListClient(t, header,
+		`{"status":1,"since":100,"list":{"1":{"item_id":"1","given_url":"https://example.com/a","status":"0"}}}`)
+
+	_, err := c.Existing(context.Background(), []string{"https://example.com/a"})
+	if !errors.Is(err, errRateLimited) {
+		t.Errorf("Existing error = %v, want rate limited", err)
+	}
+	if *calls != 1 {
+		t.Errorf("got %d calls, want no request after quota is exhausted", *calls)
+	}
+}
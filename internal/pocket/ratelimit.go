@@ -0,0 +1,74 @@
+//
+// ratelimit.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package pocket
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Number of calls left for other apps before pausing
+const rateLimitReserve = 1
+
+// RateLimit is the last known Pocket API quota from X-Limit-* response headers.
+// Pocket limits the number of calls per hour for each user and each consumer key.
+type RateLimit struct {
+	UserLimit     int
+	UserRemaining int
+	UserReset     time.Time
+	KeyLimit      int
+	KeyRemaining  int
+	KeyReset      time.Time
+}
+
+func (rl RateLimit) String() string {
+	return fmt.Sprintf("user=%d/%d (reset %s), key=%d/%d (reset %s)",
+		rl.UserRemaining, rl.UserLimit, rl.UserReset.Format(time.DateTime),
+		rl.KeyRemaining, rl.KeyLimit, rl.KeyReset.Format(time.DateTime))
+}
+
+// update updates the quota from the response headers if they exist.
+// It returns false if there is no rate limit header.
+func (rl *RateLimit) update(header http.Header, now time.Time) bool {
+	found := false
+	parse := func(key string, value *int) {
+		if v, err := strconv.Atoi(header.Get(key)); err == nil {
+			*value = v
+			found = true
+		}
+	}
+	parseReset := func(key string, value *time.Time) {
+		// Seconds until the current rate limit window resets
+		if v, err := strconv.Atoi(header.Get(key)); err == nil {
+			*value = now.Add(time.Duration(max(0, v)) * time.Second)
+			found = true
+		}
+	}
+	parse("X-Limit-User-Limit", &rl.UserLimit)
+	parse("X-Limit-User-Remaining", &rl.UserRemaining)
+	parseReset("X-Limit-User-Reset", &rl.UserReset)
+	parse("X-Limit-Key-Limit", &rl.KeyLimit)
+	parse("X-Limit-Key-Remaining", &rl.KeyRemaining)
+	parseReset("X-Limit-Key-Reset", &rl.KeyReset)
+	return found
+}
+
+// waitTime returns how long to wait for the quota to reset before the next call.
+// It returns zero if the quota is not nearly exhausted.
+func (rl RateLimit) waitTime(now time.Time) time.Duration {
+	wait := time.Duration(0)
+	if rl.UserLimit > 0 && rl.UserRemaining <= rateLimitReserve {
+		wait = max(wait, rl.UserReset.Sub(now))
+	}
+	if rl.KeyLimit > 0 && rl.KeyRemaining <= rateLimitReserve {
+		wait = max(wait, rl.KeyReset.Sub(now))
+	}
+	return wait
+}
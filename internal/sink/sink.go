@@ -0,0 +1,73 @@
+//
+// sink.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package sink
+
+import (
+	"context"
+	"time"
+)
+
+// Sink is a destination of new feed items e.g. a read-later service.
+type Sink interface {
+	// Name of the sink for logging
+	Name() string
+	// AddItems adds the items and returns the result of each item in the same order.
+	// Waits for rate limits and retries stop when the context is done. Requests in flight are not interrupted.
+	AddItems(ctx context.Context, items []Item) []Result
+}
+
+type Item struct {
+	Url   string
+	Title string
+	Time  time.Time
+	Tags  []string
+}
+
+type Result struct {
+	Item Item
+	Err  error
+}
+
+func (r Result) Ok() bool {
+	return r.Err == nil
+}
+
+// DefaultBatch is the default number of items to send in one batch.
+const DefaultBatch = 20
+
+// Batches splits the items into batches of the size. Non-positive size means DefaultBatch.
+func Batches(items []Item, size int) [][]Item {
+	if size <= 0 {
+		size = DefaultBatch
+	}
+	batches := make([][]Item, 0, (len(items)+size-1)/size)
+	for i := 0; i < len(items); i = i + size {
+		batches = append(batches, items[i:min(i+size, len(items))])
+	}
+	return batches
+}
+
+// Results returns the results of all items with the same error. Nil error means success.
+func Results(items []Item, err error) []Result {
+	results := make([]Result, 0, len(items))
+	for _, item := range items {
+		results = append(results, Result{Item: item, Err: err})
+	}
+	return results
+}
+
+// CountFailed counts failed results.
+func CountFailed(results []Result) int {
+	count := 0
+	for _, r := range results {
+		if !r.Ok() {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,88 @@
+//
+// public.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package util
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// Most redirects to follow by PublicClient
+const maxPublicRedirects = 5
+
+// Reserved ranges which are not covered by netip.Addr methods
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved and broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64 which may map to private IPv4
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2001::/23"),      // IETF protocol assignments e.g. Teredo
+	netip.MustParsePrefix("2002::/16"),      // 6to4 which may map to private IPv4
+}
+
+// IsPublicAddr checks that the address is a public unicast address,
+// not loopback, private, link-local (e.g. cloud metadata) or reserved.
+func IsPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPublicAddr is the dialer control to reject connections to non-public addresses.
+// It is called after DNS resolution so hostnames resolving to internal addresses are rejected too.
+func checkPublicAddr(network string, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	if !IsPublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("address %s is not public", addrPort.Addr())
+	}
+	return nil
+}
+
+// PublicClient creates a client which connects only to public addresses, also after redirects.
+// It is for urls from feed contents which must not reach the host network. Zero timeout means no timeout.
+func PublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: checkPublicAddr,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Proxy would connect on our behalf without the address check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxPublicRedirects {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %s", req.URL.Scheme)
+			}
+			// Addresses of redirects are checked by the dialer
+			return nil
+		},
+	}
+}
@@ -11,8 +11,11 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const AppVersion = "v0.3.0"
@@ -70,6 +73,58 @@ func CreateWorkDir(path *string, clean bool) (bool, error) {
 	}
 }
 
+// ResolveValue resolves a config value which may be loaded from somewhere else.
+//   - "env:NAME" is the value of environment variable NAME.
+//   - "file:PATH" is the content of file PATH without surrounding whitespaces.
+//   - Otherwise, it is the value itself.
+func ResolveValue(value string) (string, error) {
+	if name, ok := strings.CutPrefix(value, "env:"); ok {
+		v, found := os.LookupEnv(name)
+		if !found {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	}
+	if path, ok := strings.CutPrefix(value, "file:"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading value file: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return value, nil
+}
+
+// ParseRetryAfter parses Retry-After header value in either seconds or HTTP date.
+func ParseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(0, secs)) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(t))
+	}
+	return 0
+}
+
+// AttemptError is a failed attempt of a request which may succeed if it is retried.
+type AttemptError struct {
+	Err       error
+	Retryable bool
+	// Time to wait before retrying from Retry-After header. Zero if not given.
+	RetryAfter time.Duration
+}
+
+func (e *AttemptError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *AttemptError) Unwrap() error {
+	return e.Err
+}
+
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
 func RandString(length int) string {
@@ -0,0 +1,184 @@
+//
+// wallabag.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package wallabag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+	"github.com/teerapap/feed-to-pocket/internal/sink"
+	"github.com/teerapap/feed-to-pocket/internal/util"
+)
+
+// Config of Wallabag client. Secret values can be loaded with "env:NAME" or "file:PATH".
+type Config struct {
+	Url          string `toml:"url"`
+	ClientId     string `toml:"client_id"`
+	ClientSecret string `toml:"client_secret"`
+	Username     string `toml:"username"`
+	Password     string `toml:"password"`
+}
+
+type Client struct {
+	Config      Config
+	baseUrl     *url.URL
+	client      *http.Client
+	accessToken string
+	expiry      time.Time
+}
+
+var errUnauthorized = errors.New("unauthorized")
+
+func NewClient(config Config) (*Client, error) {
+	baseUrl, err := url.Parse(config.Url)
+	if err != nil {
+		return nil, fmt.Errorf("wallabag.url is not valid: %w", err)
+	}
+	return &Client{
+		Config:  config,
+		baseUrl: baseUrl,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (c *Client) Name() string {
+	return "Wallabag"
+}
+
+type newEntry struct {
+	Url         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Tags        string `json:"tags,omitempty"`
+	PublishedAt string `json:"published_at,omitempty"`
+}
+
+func (c *Client) AddItems(ctx context.Context, items []sink.Item) []sink.Result {
+	results := make([]sink.Result, 0, len(items))
+	if len(items) == 0 {
+		return results
+	}
+	log.Printf("Adding %d new items to Wallabag", len(items))
+
+	for _, item := range items {
+		entry := newEntry{
+			Url:   item.Url,
+			Title: item.Title,
+			Tags:  strings.Join(item.Tags, ","),
+		}
+		if !item.Time.IsZero() {
+			entry.PublishedAt = item.Time.Format(time.RFC3339)
+		}
+		jsonBody, err := json.Marshal(entry)
+		if err != nil {
+			results = append(results, sink.Result{Item: item, Err: fmt.Errorf("encoding request in json: %w", err)})
+			continue
+		}
+
+		err = c.addEntry(jsonBody)
+		if errors.Is(err, errUnauthorized) {
+			// Token may be revoked. Authenticate again and retry once.
+			c.accessToken = ""
+			err = c.addEntry(jsonBody)
+		}
+		if err != nil {
+			log.Errorf("Failed to add %s: %s", item.Url, err)
+		}
+		results = append(results, sink.Result{Item: item, Err: err})
+	}
+
+	return results
+}
+
+func (c *Client) addEntry(jsonBody []byte) error {
+	log.Indent()
+	defer log.Unindent()
+	log.Verbosef("Request Body: %s", string(jsonBody))
+
+	token, err := c.token()
+	if err != nil {
+		return fmt.Errorf("authenticating: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.baseUrl.JoinPath("api", "entries.json").String(), bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return fmt.Errorf("creating api request in json: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("api request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("api response failure: %s", resp.Status)
+	}
+	return nil
+}
+
+// token returns the access token. It requests a new one by OAuth2 password grant if expired.
+func (c *Client) token() (string, error) {
+	if c.accessToken != "" && time.Now().Before(c.expiry) {
+		return c.accessToken, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "password")
+	for key, value := range map[string]string{
+		"client_id":     c.Config.ClientId,
+		"client_secret": c.Config.ClientSecret,
+		"username":      c.Config.Username,
+		"password":      c.Config.Password,
+	} {
+		v, err := util.ResolveValue(value)
+		if err != nil {
+			return "", fmt.Errorf("wallabag.%s: %w", key, err)
+		}
+		form.Set(key, v)
+	}
+
+	log.Verbosef("Requesting Wallabag access token")
+	resp, err := c.client.PostForm(c.baseUrl.JoinPath("oauth", "v2", "token").String(), form)
+	if err != nil {
+		return "", fmt.Errorf("token request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token response failure: %s", resp.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding token response: %w", err)
+	}
+	if body.AccessToken == "" {
+		return "", fmt.Errorf("no access token in response")
+	}
+
+	c.accessToken = body.AccessToken
+	// Renew a bit before it actually expires
+	c.expiry = time.Now().Add(time.Duration(body.ExpiresIn)*time.Second - time.Minute)
+	return c.accessToken, nil
+}
@@ -0,0 +1,212 @@
+//
+// wallabag_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package wallabag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+	"github.com/teerapap/feed-to-pocket/internal/sink"
+)
+
+func init() {
+	log.Initialize(io.Discard)
+}
+
+// stubServer is a local Wallabag API which issues tokens and records entries.
+type stubServer struct {
+	mu         sync.Mutex
+	tokenForms []map[string]string
+	token      string // current valid token
+	entries    []newEntry
+	failUrl    string // entry url to fail with 500
+}
+
+func (s *stubServer) handler(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth/v2/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing token form: %s", err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		form := make(map[string]string)
+		for key := range r.PostForm {
+			form[key] = r.PostForm.Get(key)
+		}
+		s.tokenForms = append(s.tokenForms, form)
+		s.token = fmt.Sprintf("token-%d", len(s.tokenForms))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":%q,"expires_in":3600,"token_type":"bearer"}`, s.token)
+	})
+	mux.HandleFunc("POST /api/entries.json", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer "+s.token {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		var entry newEntry
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if entry.Url == s.failUrl {
+			http.Error(w, "failed", http.StatusInternalServerError)
+			return
+		}
+		s.entries = append(s.entries, entry)
+		fmt.Fprint(w, `{"id":1}`)
+	})
+	return mux
+}
+
+func newTestClient(t *testing.T) (*Client, *stubServer) {
+	stub := &stubServer{}
+	srv := httptest.NewServer(stub.handler(t))
+	t.Cleanup(srv.Close)
+	t.Setenv("WALLABAG_TEST_PASSWORD", "secret-password")
+
+	c, err := NewClient(Config{
+		Url:          srv.URL,
+		ClientId:     "client-id",
+		ClientSecret: "client-secret",
+		Username:     "user",
+		Password:     "env:WALLABAG_TEST_PASSWORD",
+	})
+	if err != nil {
+		t.Fatalf("creating client: %s", err)
+	}
+	return c, stub
+}
+
+func TestAddItems(t *testing.T) {
+	c, stub := newTestClient(t)
+	published := time.Date(2024, 10, 1, 12, 30, 0, 0, time.UTC)
+	items := []sink.Item{
+		{Url: "https://example.com/a", Title: "A", Time: published, Tags: []string{"news", "tech"}},
+		{Url: "https://example.com/b", Title: "B"},
+	}
+
+	results := c.AddItems(context.Background(), items)
+	if len(results) != len(items) {
+		t.Fatalf("got %d results, want %d", len(results), len(items))
+	}
+	for i, r := range results {
+		if !r.Ok() {
+			t.Errorf("item %d failed: %s", i, r.Err)
+		}
+	}
+
+	// Password grant with resolved secrets
+	if len(stub.tokenForms) != 1 {
+		t.Fatalf("got %d token requests, want 1", len(stub.tokenForms))
+	}
+	wantForm := map[string]string{
+		"grant_type":    "password",
+		"client_id":     "client-id",
+		"client_secret": "client-secret",
+		"username":      "user",
+		"password":      "secret-password",
+	}
+	for key, want := range wantForm {
+		if got := stub.tokenForms[0][key]; got != want {
+			t.Errorf("token form %s = %q, want %q", key, got, want)
+		}
+	}
+
+	// Entry mapping
+	wantEntries := []newEntry{
+		{Url: "https://example.com/a", Title: "A", Tags: "news,tech", PublishedAt: "2024-10-01T12:30:00Z"},
+		{Url: "https://example.com/b", Title: "B"},
+	}
+	if len(stub.entries) != len(wantEntries) {
+		t.Fatalf("got %d entries, want %d", len(stub.entries), len(wantEntries))
+	}
+	for i, want := range wantEntries {
+		if got := stub.entries[i]; got != want {
+			t.Errorf("entry %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestAddItemsRenewsRevokedToken(t *testing.T) {
+	c, stub := newTestClient(t)
+	if results := c.AddItems(context.Background(), []sink.Item{{Url: "https://example.com/a"}}); !results[0].Ok() {
+		t.Fatalf("first item failed: %s", results[0].Err)
+	}
+
+	// Revoke the token before it expires
+	stub.mu.Lock()
+	stub.token = "revoked"
+	stub.mu.Unlock()
+
+	results := c.AddItems(context.Background(), []sink.Item{{Url: "https://example.com/b"}})
+	if !results[0].Ok() {
+		t.Fatalf("item after 401 failed: %s", results[0].Err)
+	}
+	if len(stub.tokenForms) != 2 {
+		t.Errorf("got %d token requests, want 2", len(stub.tokenForms))
+	}
+	if len(stub.entries) != 2 {
+		t.Errorf("got %d entries, want 2", len(stub.entries))
+	}
+}
+
+func TestAddItemsPartialFailure(t *testing.T) {
+	c, stub := newTestClient(t)
+	stub.failUrl = "https://example.com/bad"
+	items := []sink.Item{
+		{Url: "https://example.com/a"},
+		{Url: "https://example.com/bad"},
+		{Url: "https://example.com/c"},
+	}
+
+	results := c.AddItems(context.Background(), items)
+	if len(results) != len(items) {
+		t.Fatalf("got %d results, want %d", len(results), len(items))
+	}
+	for i, r := range results {
+		if r.Item.Url != items[i].Url {
+			t.Errorf("result %d is for %s, want %s", i, r.Item.Url, items[i].Url)
+		}
+		if wantErr := items[i].Url == stub.failUrl; (r.Err != nil) != wantErr {
+			t.Errorf("result %d error = %v, want error %t", i, r.Err, wantErr)
+		}
+	}
+	if got := sink.CountFailed(results); got != 1 {
+		t.Errorf("CountFailed = %d, want 1", got)
+	}
+	if len(stub.entries) != 2 {
+		t.Errorf("got %d entries, want 2", len(stub.entries))
+	}
+}
+
+func TestAddItemsTokenFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad credentials", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{Url: srv.URL})
+	if err != nil {
+		t.Fatalf("creating client: %s", err)
+	}
+
+	results := c.AddItems(context.Background(), []sink.Item{{Url: "https://example.com/a"}, {Url: "https://example.com/b"}})
+	if got := sink.CountFailed(results); got != 2 {
+		t.Errorf("CountFailed = %d, want 2", got)
+	}
+}
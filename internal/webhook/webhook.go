@@ -0,0 +1,180 @@
+//
+// webhook.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+	"github.com/teerapap/feed-to-pocket/internal/sink"
+	"github.com/teerapap/feed-to-pocket/internal/util"
+)
+
+// Config of webhook client. Header values and secret can be loaded with "env:NAME" or "file:PATH".
+type Config struct {
+	Url             string            `toml:"url"`
+	Headers         map[string]string `toml:"headers,omitempty"`
+	BodyTemplate    string            `toml:"body_template,omitempty"`
+	Secret          string            `toml:"secret,omitempty"`
+	SignatureHeader string            `toml:"signature_header,omitempty"`
+	Batch           int               `toml:"batch"`
+}
+
+type Client struct {
+	Config   Config
+	bodyTmpl *template.Template
+	client   *http.Client
+}
+
+const defaultSignatureHeader = "X-Signature-256"
+
+// Default body is a json object of all items in the batch
+const defaultBodyTemplate = `{"items":{{ json .Items }}}`
+
+func NewClient(config Config) (*Client, error) {
+	if config.BodyTemplate == "" {
+		config.BodyTemplate = defaultBodyTemplate
+	}
+	if config.SignatureHeader == "" {
+		config.SignatureHeader = defaultSignatureHeader
+	}
+	bodyTmpl, err := template.New("webhook-body").Funcs(template.FuncMap{
+		"json": toJson,
+	}).Parse(config.BodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("webhook.body_template is not valid: %w", err)
+	}
+	return &Client{
+		Config:   config,
+		bodyTmpl: bodyTmpl,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (c *Client) Name() string {
+	return "Webhook"
+}
+
+// Item is an item in the body template.
+type Item struct {
+	Url   string    `json:"url"`
+	Title string    `json:"title,omitempty"`
+	Time  time.Time `json:"time"`
+	Tags  []string  `json:"tags,omitempty"`
+}
+
+// BodyData is the data of the body template. The rendered body must be valid json
+// so every value should be encoded with json function e.g. {"title":{{ json .Item.Title }}}.
+type BodyData struct {
+	// All items in the batch
+	Items []Item
+	// The first item in the batch. Useful when batch is 1.
+	Item Item
+}
+
+func toJson(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+func (c *Client) AddItems(ctx context.Context, items []sink.Item) []sink.Result {
+	results := make([]sink.Result, 0, len(items))
+	if len(items) == 0 {
+		return results
+	}
+	log.Printf("Sending %d new items to webhook", len(items))
+
+	batches := sink.Batches(items, c.Config.Batch)
+	for i, bItems := range batches {
+		if len(batches) > 1 {
+			log.Printf("(Batch %d) %d items", i+1, len(bItems))
+		}
+
+		data := BodyData{Items: make([]Item, 0, len(bItems))}
+		for _, item := range bItems {
+			data.Items = append(data.Items, Item{
+				Url:   item.Url,
+				Title: item.Title,
+				Time:  item.Time,
+				Tags:  item.Tags,
+			})
+		}
+		data.Item = data.Items[0]
+
+		// The remaining batches are sent even if this batch fails
+		body := new(bytes.Buffer)
+		if err := c.bodyTmpl.Execute(body, data); err != nil {
+			log.Errorf("Failed to send batch: executing body template: %s", err)
+			results = append(results, sink.Results(bItems, fmt.Errorf("executing body template: %w", err))...)
+			continue
+		}
+		if !json.Valid(body.Bytes()) {
+			// e.g. a title with quotes in the template without json function
+			err := fmt.Errorf("body template renders invalid json. Encode values with json function")
+			log.Errorf("Failed to send batch: %s: %s", err, body.String())
+			results = append(results, sink.Results(bItems, err)...)
+			continue
+		}
+		if err := c.send(body.Bytes()); err != nil {
+			log.Errorf("Failed to send batch: %s", err)
+			results = append(results, sink.Results(bItems, err)...)
+			continue
+		}
+		results = append(results, sink.Results(bItems, nil)...)
+	}
+
+	return results
+}
+
+func (c *Client) send(body []byte) error {
+	log.Indent()
+	defer log.Unindent()
+	log.Verbosef("Request Body: %s", string(body))
+
+	req, err := http.NewRequest("POST", c.Config.Url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	for key, value := range c.Config.Headers {
+		v, err := util.ResolveValue(value)
+		if err != nil {
+			return fmt.Errorf("webhook.headers.%s: %w", key, err)
+		}
+		req.Header.Set(key, v)
+	}
+	if c.Config.Secret != "" {
+		secret, err := util.ResolveValue(c.Config.Secret)
+		if err != nil {
+			return fmt.Errorf("webhook.secret: %w", err)
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set(c.Config.SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook response failure: %s", resp.Status)
+	}
+	return nil
+}
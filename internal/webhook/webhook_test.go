@@ -0,0 +1,176 @@
+//
+// webhook_test.go
+// Copyright (C) 2024 Teerapap Changwichukarn <teerapap.c@gmail.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/teerapap/feed-to-pocket/internal/log"
+	"github.com/teerapap/feed-to-pocket/internal/sink"
+)
+
+func init() {
+	log.Initialize(io.Discard)
+}
+
+// newTestServer records received bodies and fails requests of which body contains failText.
+func newTestServer(t *testing.T, failText string) (*httptest.Server, *[]string) {
+	bodies := make([]string, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+		if failText != "" && strings.Contains(string(data), failText) {
+			http.Error(w, "failed", http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &bodies
+}
+
+func TestAddItemsInvalidJson(t *testing.T) {
+	srv, bodies := newTestServer(t, "")
+	c, err := NewClient(Config{
+		Url:          srv.URL,
+		Batch:        1,
+		BodyTemplate: `{"title":"{{ .Item.Title }}"}`,
+	})
+	if err != nil {
+		t.Fatalf("creating client: %s", err)
+	}
+	items := []sink.Item{
+		{Url: "https://example.com/a", Title: `Say "hi"`},
+		{Url: "https://example.com/b", Title: "Plain"},
+	}
+
+	results := c.AddItems(context.Background(), items)
+	if results[0].Ok() || !strings.Contains(results[0].Err.Error(), "invalid json") {
+		t.Errorf("result 0 error = %v, want invalid json", results[0].Err)
+	}
+	if !results[1].Ok() {
+		t.Errorf("result 1 failed: %s", results[1].Err)
+	}
+	if len(*bodies) != 1 || (*bodies)[0] != `{"title":"Plain"}` {
+		t.Errorf("received bodies = %q, want only the valid one", *bodies)
+	}
+}
+
+func TestAddItemsContinuesAfterFailedBatch(t *testing.T) {
+	srv, bodies := newTestServer(t, "/bad")
+	c, err := NewClient(Config{Url: srv.URL, Batch: 2})
+	if err != nil {
+		t.Fatalf("creating client: %s", err)
+	}
+	items := []sink.Item{
+		{Url: "https://example.com/a"},
+		{Url: "https://example.com/bad"},
+		{Url: "https://example.com/c"},
+	}
+
+	results := c.AddItems(context.Background(), items)
+	if len(results) != len(items) {
+		t.Fatalf("got %d results, want %d", len(results), len(items))
+	}
+	for i, wantOk := range []bool{false, false, true} {
+		if results[i].Item.Url != items[i].Url {
+			t.Errorf("result %d is for %s, want %s", i, results[i].Item.Url, items[i].Url)
+		}
+		if results[i].Ok() != wantOk {
+			t.Errorf("result %d ok = %t, want %t (error %v)", i, results[i].Ok(), wantOk, results[i].Err)
+		}
+	}
+	if len(*bodies) != 2 {
+		t.Fatalf("got %d requests, want 2", len(*bodies))
+	}
+	var body struct {
+		Items []Item `json:"items"`
+	}
+	if err := json.Unmarshal([]byte((*bodies)[1]), &body); err != nil {
+		t.Fatalf("decoding second body: %s", err)
+	}
+	if len(body.Items) != 1 || body.Items[0].Url != "https://example.com/c" {
+		t.Errorf("second batch = %+v, want only https://example.com/c", body.Items)
+	}
+}
+
+func TestAddItemsSignatureAndHeaders(t *testing.T) {
+	const secret = "webhook-secret"
+	t.Setenv("WEBHOOK_TEST_TOKEN", "env-token")
+	tests := []struct {
+		name            string
+		signatureHeader string
+		wantHeader      string
+	}{
+		{"default signature header", "", "X-Signature-256"},
+		{"custom signature header", "X-Hub-Signature-256", "X-Hub-Signature-256"},
+	}
+	for _, tt := range tests {
+		var header http.Header
+		var body []byte
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header.Clone()
+			body, _ = io.ReadAll(r.Body)
+		}))
+		c, err := NewClient(Config{
+			Url:             srv.URL,
+			Headers:         map[string]string{"X-Api-Key": "plain-key", "Authorization": "env:WEBHOOK_TEST_TOKEN"},
+			Secret:          secret,
+			SignatureHeader: tt.signatureHeader,
+		})
+		if err != nil {
+			t.Fatalf("%s: creating client: %s", tt.name, err)
+		}
+
+		results := c.AddItems(context.Background(), []sink.Item{{Url: "https://example.com/a", Title: "A"}})
+		srv.Close()
+		if !results[0].Ok() {
+			t.Fatalf("%s: item failed: %s", tt.name, results[0].Err)
+		}
+
+		// Signature is verified over the received body as a receiver would
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if got := header.Get(tt.wantHeader); got != want {
+			t.Errorf("%s: %s = %q, want %q", tt.name, tt.wantHeader, got, want)
+		}
+		if got := header.Get("X-Api-Key"); got != "plain-key" {
+			t.Errorf("%s: X-Api-Key = %q, want plain-key", tt.name, got)
+		}
+		if got := header.Get("Authorization"); got != "env-token" {
+			t.Errorf("%s: Authorization = %q, want env-token", tt.name, got)
+		}
+		if got := header.Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+			t.Errorf("%s: Content-Type = %q, want application/json", tt.name, got)
+		}
+	}
+}
+
+func TestAddItemsWithoutSecret(t *testing.T) {
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{Url: srv.URL})
+	if err != nil {
+		t.Fatalf("creating client: %s", err)
+	}
+	c.AddItems(context.Background(), []sink.Item{{Url: "https://example.com/a"}})
+	if got := header.Get("X-Signature-256"); got != "" {
+		t.Errorf("X-Signature-256 = %q, want no signature without secret", got)
+	}
+}